@@ -7,17 +7,36 @@ import (
 
 	"github.com/fengxsong/helm-crd/pkg/controller"
 	"github.com/golang/glog"
+	"github.com/spf13/pflag"
 )
 
 func main() {
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 
-	c, err := controller.NewController()
+	config := controller.DefaultConfig()
+	config.AddFlags(pflag.CommandLine)
+	pflag.Parse()
+
+	kubeconfig, err := config.RestConfig()
+	if err != nil {
+		glog.Fatal(err.Error())
+	}
+	c, err := controller.NewController(kubeconfig, config)
 	if err != nil {
 		glog.Fatal(err.Error())
 	}
-	go c.Run(stopCh)
+	if config.LeaderElect {
+		go c.RunWithLeaderElection(stopCh)
+	} else {
+		go c.Run(stopCh)
+	}
+
+	if config.MetricsAddr != "" {
+		go func() {
+			glog.Fatal(c.ServeMetrics(config.MetricsAddr))
+		}()
+	}
 
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, syscall.SIGTERM)
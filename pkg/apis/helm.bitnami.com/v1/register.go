@@ -34,6 +34,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&HelmRelease{},
 		&HelmReleaseList{},
+		&HelmRepository{},
+		&HelmRepositoryList{},
+		&ClusterHelmRepository{},
+		&ClusterHelmRepositoryList{},
 	)
 
 	scheme.AddKnownTypes(SchemeGroupVersion,
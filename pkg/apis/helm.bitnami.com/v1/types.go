@@ -1,6 +1,7 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -20,25 +21,286 @@ type HelmRelease struct {
 type HelmReleaseSpec struct {
 	// RepoURL is the URL of the repository. Defaults to stable repo.
 	RepoURL string `json:"repoURL,omitempty"`
+	// RepositoryRef refers to the repository of the chart, instead of
+	// RepoURL and RepoCredentialsSecretRef.
+	RepositoryRef *RepositoryReference `json:"repositoryRef,omitempty"`
 	// ChartName is the name of the chart within the repo
 	ChartName string `json:"chartName,omitempty"`
-	// Version is the chart version
+	// Version is the chart version. It may also be a semver constraint
+	// (e.g. "~2.0" or ">=1.2, <2.0"), in which case the highest matching
+	// version is installed, and the release is upgraded whenever a newer
+	// matching version appears in the repository.
 	Version string `json:"version,omitempty"`
-	// Username/Password required if repository is private
+	// PollInterval is how often the repository is checked for new versions
+	// when Version is a constraint, the Git branch or tag of Source for new
+	// commits, or an unpinned tarball or OCI tag of Source for changes.
+	// Defaults to the controller --pollInterval flag.
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+	// DependsOn lists the HelmReleases which must be ready, at their
+	// current generation, before this one is installed or upgraded.
+	DependsOn []ReleaseReference `json:"dependsOn,omitempty"`
+	// Source is where the chart is fetched from when it does not come
+	// from a chart repository. RepoURL, RepositoryRef, ChartName and
+	// Version are then ignored.
+	Source *ChartSource `json:"source,omitempty"`
+	// Username/Password required if repository is private.
+	// Deprecated: use RepoCredentialsSecretRef instead.
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+	// RepoCredentialsSecretRef is a Secret in the HelmRelease namespace
+	// holding the repository credentials. Recognised keys are "username"
+	// and "password" for basic auth, and "tls.crt", "tls.key" and "ca.crt"
+	// for TLS. It takes precedence over Username/Password.
+	RepoCredentialsSecretRef *corev1.LocalObjectReference `json:"repoCredentialsSecretRef,omitempty"`
 	// RawValues is a raw string containing extra Values added to the chart.
 	// These values override the default values inside of the chart.
 	RawValues string `json:"values,omitempty"`
+	// ValuesFrom is a list of ConfigMap/Secret keys holding extra Values.
+	// They are merged in order, and RawValues is merged on top of them.
+	ValuesFrom []ValuesReference `json:"valuesFrom,omitempty"`
 	// Force if set, force resource update through delete/recreate if needed
 	Force bool `json:"force,omitempty"`
 	// Recreate if set, performs pod restart during upgrade/rollback
 	Recreate bool `json:"recreate,omitempty"`
+	// Wait if set, waits until the resources of the release are ready
+	// before marking an install/upgrade successful, for up to Timeout.
+	Wait bool `json:"wait,omitempty"`
+	// Timeout is the time in seconds to wait for any individual Kubernetes
+	// operation (like Jobs for hooks) during install/upgrade/delete, and
+	// for the release resources with Wait. Defaults to 300.
+	Timeout int64 `json:"timeout,omitempty"`
+	// Atomic if set, implies Wait, purges the release when an install
+	// fails and rolls it back to its last good revision when an upgrade
+	// fails.
+	Atomic bool `json:"atomic,omitempty"`
+	// DisableHooks if set, prevents hooks from running during
+	// install/upgrade/delete/rollback.
+	DisableHooks bool `json:"disableHooks,omitempty"`
+	// Test configures the release tests run after installs and upgrades.
+	Test *TestSpec `json:"test,omitempty"`
 	// Paused is when a HelmRelease is paused, no actions except for deletion
 	// will be performed on the underlying objects.
 	Paused bool `json:"paused,omitempty"`
 	// Description is human-friendly "log entry" about this helmrelease.
 	Description string `json:"description,omitempty"`
+	// DeletionPolicy controls what happens to the release when the
+	// HelmRelease is deleted. Defaults to Purge.
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// Rollback configures the automatic rollback of failed upgrades.
+	Rollback *RollbackSpec `json:"rollback,omitempty"`
+	// Verify is when the chart provenance is verified. Defaults to Never.
+	Verify VerifyMode `json:"verify,omitempty"`
+	// Keyring is the public keyring charts are verified against. Defaults
+	// to the controller --keyring flag.
+	Keyring *KeyringReference `json:"keyring,omitempty"`
+	// DriftPolicy controls what happens when the resources of the release
+	// are modified or deleted behind its back. Defaults to Detect.
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+}
+
+// DriftPolicy describes how drift of the release resources from the
+// release manifest is handled.
+type DriftPolicy string
+
+const (
+	// DriftPolicyIgnore doesn't check the release resources.
+	DriftPolicyIgnore DriftPolicy = "Ignore"
+	// DriftPolicyDetect reports drifted resources in the status.
+	DriftPolicyDetect DriftPolicy = "Detect"
+	// DriftPolicyCorrect reports drifted resources and re-applies them
+	// from the release manifest.
+	DriftPolicyCorrect DriftPolicy = "Correct"
+)
+
+// VerifyMode describes when the provenance of a chart is verified.
+type VerifyMode string
+
+const (
+	// VerifyNever skips verification.
+	VerifyNever VerifyMode = "Never"
+	// VerifyIfPossible verifies the chart if it has a provenance file.
+	VerifyIfPossible VerifyMode = "IfPossible"
+	// VerifyAlways fails unless the chart has a valid provenance file.
+	VerifyAlways VerifyMode = "Always"
+)
+
+// KeyringReference selects the key of a ConfigMap or Secret in the
+// HelmRelease namespace holding a GnuPG public keyring. Exactly one of
+// ConfigMapKeyRef and SecretKeyRef must be set.
+type KeyringReference struct {
+	// ConfigMapKeyRef selects a key of a ConfigMap. Binary data is
+	// looked up before text data.
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// SecretKeyRef selects a key of a Secret.
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// RepositoryReference refers to a HelmRepository in the HelmRelease
+// namespace or to a ClusterHelmRepository.
+type RepositoryReference struct {
+	// Kind is HelmRepository or ClusterHelmRepository. Defaults to
+	// HelmRepository.
+	Kind string `json:"kind,omitempty"`
+	// Name of the repository.
+	Name string `json:"name"`
+}
+
+// ReleaseReference refers to a HelmRelease.
+type ReleaseReference struct {
+	// Namespace of the HelmRelease. Defaults to the namespace of the
+	// referring HelmRelease.
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the HelmRelease.
+	Name string `json:"name"`
+}
+
+// ChartSource describes where a chart is fetched from, other than a chart
+// repository. Exactly one field must be set.
+type ChartSource struct {
+	// Git packages a chart directory of a Git repository.
+	Git *GitSource `json:"git,omitempty"`
+	// Tarball downloads a chart archive.
+	Tarball *TarballSource `json:"tarball,omitempty"`
+	// OCI pulls a chart stored as an OCI artifact in a registry.
+	OCI *OCISource `json:"oci,omitempty"`
+	// ConfigMapKeyRef selects a key of a ConfigMap in the HelmRelease
+	// namespace holding a chart archive. Binary data is looked up before
+	// text data.
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// SecretKeyRef selects a key of a Secret in the HelmRelease namespace
+	// holding a chart archive.
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// TarballSource is a chart archive (.tgz) served at a URL.
+type TarballSource struct {
+	// URL of the archive.
+	URL string `json:"url"`
+	// Digest is the SHA-256 of the archive, in hex and optionally
+	// prefixed with "sha256:". The archive is rejected if it doesn't
+	// match. Unpinned archives are downloaded every poll interval to
+	// detect changes.
+	Digest string `json:"digest,omitempty"`
+	// CredentialsSecretRef is a Secret in the HelmRelease namespace
+	// holding the credentials of the server, with the same keys as
+	// RepoCredentialsSecretRef.
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+}
+
+// OCISource is a chart stored as an OCI artifact.
+type OCISource struct {
+	// URL of the chart, such as oci://registry.example.com/charts/mychart:1.0.0.
+	// The tag may be replaced with a digest (@sha256:...), and defaults to
+	// "latest".
+	URL string `json:"url"`
+	// Digest pins the "sha256:" digest of the chart manifest. The chart is
+	// rejected if the tag doesn't resolve to it. Tags of unpinned charts
+	// are resolved every poll interval.
+	Digest string `json:"digest,omitempty"`
+	// PullSecrets are kubernetes.io/dockerconfigjson Secrets in the
+	// HelmRelease namespace holding the credentials of the registry.
+	PullSecrets []corev1.LocalObjectReference `json:"pullSecrets,omitempty"`
+	// PlainHTTP talks to the registry over HTTP instead of HTTPS.
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+}
+
+// GitSource is a chart directory in a Git repository.
+type GitSource struct {
+	// URL of the repository, an https://, ssh:// or git:// URL, or an
+	// SSH user@host:path.
+	URL string `json:"url"`
+	// Ref is the branch, tag or commit checked out. Defaults to the
+	// default branch of the repository.
+	Ref *GitReference `json:"ref,omitempty"`
+	// Path is the chart directory in the repository. Defaults to the
+	// root of the repository.
+	Path string `json:"path,omitempty"`
+	// SecretRef is a Secret in the HelmRelease namespace holding the
+	// credentials of the repository. Recognised keys are "identity" (an
+	// SSH private key) and the required "known_hosts" for SSH, and
+	// "username" and "password" (or an access token) for HTTPS.
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// GitReference selects a commit of a Git repository. At most one field
+// may be set.
+type GitReference struct {
+	// Branch is followed, the release being upgraded when it moves. Moves
+	// are checked every spec.pollInterval.
+	Branch string `json:"branch,omitempty"`
+	// Tag is checked out. Like branches, tags are checked for changes
+	// every poll interval.
+	Tag string `json:"tag,omitempty"`
+	// Commit is the full SHA-1 of the commit.
+	Commit string `json:"commit,omitempty"`
+}
+
+// RollbackSpec configures how failed upgrades are rolled back to the last
+// good revision.
+type RollbackSpec struct {
+	// Enable turns automatic rollback on.
+	Enable bool `json:"enable,omitempty"`
+	// Timeout is the time in seconds to wait for any individual Kubernetes
+	// operation during the rollback. Defaults to 300.
+	Timeout int64 `json:"timeout,omitempty"`
+	// Wait if set, waits until all resources are ready before marking the
+	// rollback successful.
+	Wait bool `json:"wait,omitempty"`
+	// Force if set, force resource update through delete/recreate if needed.
+	Force bool `json:"force,omitempty"`
+	// Recreate if set, performs pod restart during rollback.
+	Recreate bool `json:"recreate,omitempty"`
+	// DisableHooks if set, prevents hooks from running during rollback.
+	DisableHooks bool `json:"disableHooks,omitempty"`
+	// MaxRetries is the number of times the upgrade is retried after being
+	// rolled back, until the spec changes. Defaults to 0.
+	MaxRetries int32 `json:"maxRetries,omitempty"`
+}
+
+// TestSpec configures the release tests (helm test) run after every
+// successful install/upgrade.
+type TestSpec struct {
+	// Enable turns the release tests on.
+	Enable bool `json:"enable,omitempty"`
+	// Timeout is the time in seconds to wait for each test. Defaults to
+	// 300.
+	Timeout int64 `json:"timeout,omitempty"`
+	// Cleanup if set, deletes the test pods once the tests are done.
+	Cleanup bool `json:"cleanup,omitempty"`
+	// RollbackOnFailure if set, rolls upgrades whose tests fail back to
+	// the last good revision, as spec.rollback does for failed upgrades.
+	RollbackOnFailure bool `json:"rollbackOnFailure,omitempty"`
+}
+
+// DeletionPolicy describes how the release is handled when its HelmRelease
+// is deleted.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyPurge deletes the release and purges its history.
+	DeletionPolicyPurge DeletionPolicy = "Purge"
+	// DeletionPolicyKeep deletes the release but keeps its history.
+	DeletionPolicyKeep DeletionPolicy = "Keep"
+	// DeletionPolicyOrphan leaves the release installed.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+// ReleaseFinalizer is added to HelmReleases so the controller can delete
+// the release before the HelmRelease goes away.
+const ReleaseFinalizer = "helm.bitnami.com/release"
+
+// ValuesReference selects a key of a ConfigMap or Secret in the
+// HelmRelease namespace. Exactly one of ConfigMapKeyRef and SecretKeyRef
+// must be set.
+type ValuesReference struct {
+	// ConfigMapKeyRef selects a key of a ConfigMap.
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// SecretKeyRef selects a key of a Secret.
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+	// TargetPath is an optional dot-separated path inside the values tree
+	// (e.g. "mariadb.auth") where the referenced content is placed.
+	// Defaults to the root of the values tree.
+	TargetPath string `json:"targetPath,omitempty"`
 }
 
 // HelmRealeasePhase represents the current life-cycle phase of a HelmRelease.
@@ -51,6 +313,15 @@ const (
 	HelmRealeasePhaseReady HelmRealeasePhase = "Ready"
 	// HelmRealeasePhaseFailed means the helmrelease has terminated with an error.
 	HelmRealeasePhaseFailed HelmRealeasePhase = "Failed"
+	// HelmRealeasePhaseRolledBack means an upgrade failed and the release has
+	// been rolled back to the last good revision.
+	HelmRealeasePhaseRolledBack HelmRealeasePhase = "RolledBack"
+	// HelmRealeasePhaseWaiting means the helmrelease waits for the
+	// releases it depends on to be ready.
+	HelmRealeasePhaseWaiting HelmRealeasePhase = "Waiting"
+	// HelmRealeasePhaseDeploying means an install/upgrade is waiting for
+	// the resources of the release to be ready.
+	HelmRealeasePhaseDeploying HelmRealeasePhase = "Deploying"
 )
 
 // HelmReleaseStatus captures the current status of a HelmRelease.
@@ -63,6 +334,180 @@ type HelmReleaseStatus struct {
 	Revision int32 `json:"revision,omitempty"`
 	// FailMsg is error message
 	FailMsg string `json:"failMsg,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the
+	// controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the latest observations of the HelmRelease state.
+	Conditions []HelmReleaseCondition `json:"conditions,omitempty"`
+	// ResolvedVersion is the chart version Version resolved to in the last
+	// successful install/upgrade.
+	ResolvedVersion string `json:"resolvedVersion,omitempty"`
+	// LastAttemptedVersion is the chart version of the last install/upgrade
+	// attempt.
+	LastAttemptedVersion string `json:"lastAttemptedVersion,omitempty"`
+	// Source is the resolved spec.source of the last successful
+	// install/upgrade.
+	Source *SourceStatus `json:"source,omitempty"`
+	// LastAppliedValuesHash is the SHA-256 of the values used by the last
+	// successful install/upgrade.
+	LastAppliedValuesHash string `json:"lastAppliedValuesHash,omitempty"`
+	// LastAttemptTime is when the last install/upgrade was attempted.
+	LastAttemptTime *metav1.Time `json:"lastAttemptTime,omitempty"`
+	// LastSuccessTime is when the last install/upgrade succeeded.
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// LastGoodRevision is the last release revision known to be deployed
+	// successfully, used as the rollback target.
+	LastGoodRevision int32 `json:"lastGoodRevision,omitempty"`
+	// Failures is the number of upgrades of the observed generation that
+	// failed and were rolled back.
+	Failures int32 `json:"failures,omitempty"`
+	// RollbackHistory records the most recent automatic rollbacks.
+	RollbackHistory []RollbackRecord `json:"rollbackHistory,omitempty"`
+	// Verification is the result of the provenance verification of the
+	// last downloaded chart, if it was verified.
+	Verification *VerificationStatus `json:"verification,omitempty"`
+	// Drift is the result of the last drift check of the release
+	// resources.
+	Drift *DriftStatus `json:"drift,omitempty"`
+	// Resources summarizes the readiness of the Deployments, StatefulSets,
+	// DaemonSets, Jobs and PersistentVolumeClaims of the release.
+	Resources []ResourceStatus `json:"resources,omitempty"`
+	// Tests are the results of the last run of the release tests.
+	Tests *TestStatus `json:"tests,omitempty"`
+}
+
+// TestStatus describes a run of the release tests.
+type TestStatus struct {
+	// Revision is the release revision that was tested.
+	Revision int32 `json:"revision"`
+	// StartTime is when the tests started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when the tests completed.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Results lists the outcome of each test.
+	Results []TestResult `json:"results,omitempty"`
+}
+
+// TestResult is the outcome of a release test.
+type TestResult struct {
+	// Name of the test pod.
+	Name string `json:"name"`
+	// Phase is Success, Failure, Running or Unknown.
+	Phase string `json:"phase"`
+	// Message describes the outcome of the test.
+	Message string `json:"message,omitempty"`
+}
+
+// ResourceStatus summarizes the readiness of a resource of the release.
+type ResourceStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Ready is whether the resource is rolled out and available, or
+	// complete for Jobs and bound for PersistentVolumeClaims.
+	Ready bool `json:"ready"`
+	// Message describes the readiness of the resource, such as
+	// "2/3 replicas available".
+	Message string `json:"message,omitempty"`
+}
+
+// DriftStatus describes the release resources found to differ from the
+// release manifest.
+type DriftStatus struct {
+	// LastCheckTime is when the resources were last compared to the
+	// manifest.
+	LastCheckTime metav1.Time `json:"lastCheckTime"`
+	// Resources lists the drifted resources.
+	Resources []DriftedResource `json:"resources,omitempty"`
+}
+
+// DriftedResource describes a resource of the release which differs from
+// the release manifest.
+type DriftedResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Reason is Missing if the resource was deleted, or Modified.
+	Reason string `json:"reason"`
+	// Fields are the paths of the first fields of the manifest found to
+	// differ in the live resource.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// VerificationStatus describes a verified chart.
+type VerificationStatus struct {
+	// SignedBy lists the identities of the key the chart was signed with.
+	SignedBy []string `json:"signedBy,omitempty"`
+	// FileHash is the hash of the chart archive, as recorded in its
+	// provenance file.
+	FileHash string `json:"fileHash,omitempty"`
+	// Time is when the chart was verified.
+	Time metav1.Time `json:"time"`
+}
+
+// SourceStatus describes the chart source a release was installed from.
+type SourceStatus struct {
+	// URL of the source.
+	URL string `json:"url,omitempty"`
+	// Revision identifies the chart in the source: the commit of Git
+	// sources, the "sha256:" digest of chart archives, or the digest of the
+	// manifest of OCI charts.
+	Revision string `json:"revision,omitempty"`
+}
+
+// RollbackRecord describes an automatic rollback.
+type RollbackRecord struct {
+	// Time is when the rollback happened.
+	Time metav1.Time `json:"time"`
+	// Revision is the revision that was rolled back to.
+	Revision int32 `json:"revision"`
+	// Reason is the error of the failed upgrade.
+	Reason string `json:"reason,omitempty"`
+}
+
+// HelmReleaseConditionType is a valid value for HelmReleaseCondition.Type
+type HelmReleaseConditionType string
+
+const (
+	// HelmReleaseConditionDownloaded means the chart has been downloaded.
+	HelmReleaseConditionDownloaded HelmReleaseConditionType = "Downloaded"
+	// HelmReleaseConditionInstalled means the release has been
+	// installed/upgraded by Tiller.
+	HelmReleaseConditionInstalled HelmReleaseConditionType = "Installed"
+	// HelmReleaseConditionReady means the release is up to date with the
+	// HelmRelease spec.
+	HelmReleaseConditionReady HelmReleaseConditionType = "Ready"
+	// HelmReleaseConditionTested means the release tests have passed.
+	HelmReleaseConditionTested HelmReleaseConditionType = "Tested"
+	// HelmReleaseConditionVerified means the chart provenance has been
+	// verified.
+	HelmReleaseConditionVerified HelmReleaseConditionType = "Verified"
+	// HelmReleaseConditionDependenciesReady means the releases listed in
+	// spec.dependsOn are ready.
+	HelmReleaseConditionDependenciesReady HelmReleaseConditionType = "DependenciesReady"
+	// HelmReleaseConditionDrifted means resources of the release differ
+	// from the release manifest.
+	HelmReleaseConditionDrifted HelmReleaseConditionType = "Drifted"
+	// HelmReleaseConditionHealthy means the resources listed in
+	// status.resources are all ready.
+	HelmReleaseConditionHealthy HelmReleaseConditionType = "Healthy"
+)
+
+// HelmReleaseCondition describes the state of a HelmRelease at a certain
+// point.
+type HelmReleaseCondition struct {
+	// Type of the condition.
+	Type HelmReleaseConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition transitioned from
+	// one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a one-word CamelCase reason for the last transition.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable message about the last transition.
+	Message string `json:"message,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -74,3 +519,85 @@ type HelmReleaseList struct {
 
 	Items []HelmRelease `json:"items"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// HelmRepository describes a chart repository HelmReleases of its
+// namespace can refer to.
+type HelmRepository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HelmRepositorySpec   `json:"spec"`
+	Status HelmRepositoryStatus `json:"status"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterHelmRepository describes a chart repository HelmReleases of all
+// namespaces can refer to.
+type ClusterHelmRepository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HelmRepositorySpec   `json:"spec"`
+	Status HelmRepositoryStatus `json:"status"`
+}
+
+// HelmRepositorySpec is the spec for a HelmRepository or
+// ClusterHelmRepository resource.
+type HelmRepositorySpec struct {
+	// URL is the URL of the repository.
+	URL string `json:"url"`
+	// CredentialsSecretRef is a Secret holding the repository
+	// credentials, with the same keys as the HelmRelease
+	// repoCredentialsSecretRef. The Secret of a HelmRepository must be in
+	// its namespace, and the namespace must be set for a
+	// ClusterHelmRepository.
+	CredentialsSecretRef *corev1.SecretReference `json:"credentialsSecretRef,omitempty"`
+	// CABundle is a PEM encoded bundle of the certificate authorities the
+	// repository server certificate is checked against.
+	CABundle string `json:"caBundle,omitempty"`
+	// RefreshInterval is how often the repository index is checked for
+	// changes. Defaults to the controller --repoIndexRefresh flag.
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+}
+
+// HelmRepositoryStatus captures the current status of a HelmRepository or
+// ClusterHelmRepository.
+type HelmRepositoryStatus struct {
+	// ObservedGeneration is the most recent generation observed by the
+	// controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Ready is whether the last refresh of the index succeeded.
+	Ready bool `json:"ready"`
+	// Charts is the number of charts in the index.
+	Charts int32 `json:"charts,omitempty"`
+	// LastRefreshTime is when the index was last refreshed successfully.
+	LastRefreshTime *metav1.Time `json:"lastRefreshTime,omitempty"`
+	// LastError is the error of the last refresh, if it failed.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// HelmRepositoryList is a list of HelmRepository resources
+type HelmRepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []HelmRepository `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterHelmRepositoryList is a list of ClusterHelmRepository resources
+type ClusterHelmRepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ClusterHelmRepository `json:"items"`
+}
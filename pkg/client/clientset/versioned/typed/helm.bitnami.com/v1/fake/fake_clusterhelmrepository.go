@@ -0,0 +1,131 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	helm_bitnami_com_v1 "github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeClusterHelmRepositories implements ClusterHelmRepositoryInterface
+type FakeClusterHelmRepositories struct {
+	Fake *FakeHelmV1
+}
+
+var clusterhelmrepositoriesResource = schema.GroupVersionResource{Group: "helm.bitnami.com", Version: "v1", Resource: "clusterhelmrepositories"}
+
+var clusterhelmrepositoriesKind = schema.GroupVersionKind{Group: "helm.bitnami.com", Version: "v1", Kind: "ClusterHelmRepository"}
+
+// Get takes name of the clusterHelmRepository, and returns the corresponding clusterHelmRepository object, and an error if there is any.
+func (c *FakeClusterHelmRepositories) Get(name string, options v1.GetOptions) (result *helm_bitnami_com_v1.ClusterHelmRepository, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootGetAction(clusterhelmrepositoriesResource, name), &helm_bitnami_com_v1.ClusterHelmRepository{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*helm_bitnami_com_v1.ClusterHelmRepository), err
+}
+
+// List takes label and field selectors, and returns the list of ClusterHelmRepositories that match those selectors.
+func (c *FakeClusterHelmRepositories) List(opts v1.ListOptions) (result *helm_bitnami_com_v1.ClusterHelmRepositoryList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootListAction(clusterhelmrepositoriesResource, clusterhelmrepositoriesKind, opts), &helm_bitnami_com_v1.ClusterHelmRepositoryList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &helm_bitnami_com_v1.ClusterHelmRepositoryList{}
+	for _, item := range obj.(*helm_bitnami_com_v1.ClusterHelmRepositoryList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested clusterHelmRepositories.
+func (c *FakeClusterHelmRepositories) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewRootWatchAction(clusterhelmrepositoriesResource, opts))
+}
+
+// Create takes the representation of a clusterHelmRepository and creates it.  Returns the server's representation of the clusterHelmRepository, and an error, if there is any.
+func (c *FakeClusterHelmRepositories) Create(clusterHelmRepository *helm_bitnami_com_v1.ClusterHelmRepository) (result *helm_bitnami_com_v1.ClusterHelmRepository, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootCreateAction(clusterhelmrepositoriesResource, clusterHelmRepository), &helm_bitnami_com_v1.ClusterHelmRepository{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*helm_bitnami_com_v1.ClusterHelmRepository), err
+}
+
+// Update takes the representation of a clusterHelmRepository and updates it. Returns the server's representation of the clusterHelmRepository, and an error, if there is any.
+func (c *FakeClusterHelmRepositories) Update(clusterHelmRepository *helm_bitnami_com_v1.ClusterHelmRepository) (result *helm_bitnami_com_v1.ClusterHelmRepository, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateAction(clusterhelmrepositoriesResource, clusterHelmRepository), &helm_bitnami_com_v1.ClusterHelmRepository{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*helm_bitnami_com_v1.ClusterHelmRepository), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeClusterHelmRepositories) UpdateStatus(clusterHelmRepository *helm_bitnami_com_v1.ClusterHelmRepository) (*helm_bitnami_com_v1.ClusterHelmRepository, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateSubresourceAction(clusterhelmrepositoriesResource, "status", clusterHelmRepository), &helm_bitnami_com_v1.ClusterHelmRepository{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*helm_bitnami_com_v1.ClusterHelmRepository), err
+}
+
+// Delete takes name of the clusterHelmRepository and deletes it. Returns an error if one occurs.
+func (c *FakeClusterHelmRepositories) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewRootDeleteAction(clusterhelmrepositoriesResource, name), &helm_bitnami_com_v1.ClusterHelmRepository{})
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeClusterHelmRepositories) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewRootDeleteCollectionAction(clusterhelmrepositoriesResource, listOptions)
+
+	_, err := c.Fake.Invokes(action, &helm_bitnami_com_v1.ClusterHelmRepositoryList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched clusterHelmRepository.
+func (c *FakeClusterHelmRepositories) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *helm_bitnami_com_v1.ClusterHelmRepository, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootPatchSubresourceAction(clusterhelmrepositoriesResource, name, data, subresources...), &helm_bitnami_com_v1.ClusterHelmRepository{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*helm_bitnami_com_v1.ClusterHelmRepository), err
+}
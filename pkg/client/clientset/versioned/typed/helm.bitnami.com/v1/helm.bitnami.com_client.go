@@ -27,7 +27,9 @@ import (
 
 type HelmV1Interface interface {
 	RESTClient() rest.Interface
+	ClusterHelmRepositoriesGetter
 	HelmReleasesGetter
+	HelmRepositoriesGetter
 }
 
 // HelmV1Client is used to interact with features provided by the helm.bitnami.com group.
@@ -35,10 +37,18 @@ type HelmV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *HelmV1Client) ClusterHelmRepositories() ClusterHelmRepositoryInterface {
+	return newClusterHelmRepositories(c)
+}
+
 func (c *HelmV1Client) HelmReleases(namespace string) HelmReleaseInterface {
 	return newHelmReleases(c, namespace)
 }
 
+func (c *HelmV1Client) HelmRepositories(namespace string) HelmRepositoryInterface {
+	return newHelmRepositories(c, namespace)
+}
+
 // NewForConfig creates a new HelmV1Client for the given config.
 func NewForConfig(c *rest.Config) (*HelmV1Client, error) {
 	config := *c
@@ -53,8 +53,12 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=helm.bitnami.com, Version=v1
+	case v1.SchemeGroupVersion.WithResource("clusterhelmrepositories"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Helm().V1().ClusterHelmRepositories().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("helmreleases"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Helm().V1().HelmReleases().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("helmrepositories"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Helm().V1().HelmRepositories().Informer()}, nil
 
 	}
 
@@ -0,0 +1,88 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	time "time"
+
+	helm_bitnami_com_v1 "github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+	versioned "github.com/fengxsong/helm-crd/pkg/client/clientset/versioned"
+	internalinterfaces "github.com/fengxsong/helm-crd/pkg/client/informers/externalversions/internalinterfaces"
+	v1 "github.com/fengxsong/helm-crd/pkg/client/listers/helm.bitnami.com/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ClusterHelmRepositoryInformer provides access to a shared informer and lister for
+// ClusterHelmRepositories.
+type ClusterHelmRepositoryInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1.ClusterHelmRepositoryLister
+}
+
+type clusterHelmRepositoryInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+}
+
+// NewClusterHelmRepositoryInformer constructs a new informer for ClusterHelmRepository type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewClusterHelmRepositoryInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredClusterHelmRepositoryInformer(client, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredClusterHelmRepositoryInformer constructs a new informer for ClusterHelmRepository type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredClusterHelmRepositoryInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.HelmV1().ClusterHelmRepositories().List(options)
+			},
+			WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.HelmV1().ClusterHelmRepositories().Watch(options)
+			},
+		},
+		&helm_bitnami_com_v1.ClusterHelmRepository{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *clusterHelmRepositoryInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredClusterHelmRepositoryInformer(client, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *clusterHelmRepositoryInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&helm_bitnami_com_v1.ClusterHelmRepository{}, f.defaultInformer)
+}
+
+func (f *clusterHelmRepositoryInformer) Lister() v1.ClusterHelmRepositoryLister {
+	return v1.NewClusterHelmRepositoryLister(f.Informer().GetIndexer())
+}
@@ -0,0 +1,89 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	time "time"
+
+	helm_bitnami_com_v1 "github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+	versioned "github.com/fengxsong/helm-crd/pkg/client/clientset/versioned"
+	internalinterfaces "github.com/fengxsong/helm-crd/pkg/client/informers/externalversions/internalinterfaces"
+	v1 "github.com/fengxsong/helm-crd/pkg/client/listers/helm.bitnami.com/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// HelmRepositoryInformer provides access to a shared informer and lister for
+// HelmRepositories.
+type HelmRepositoryInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1.HelmRepositoryLister
+}
+
+type helmRepositoryInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewHelmRepositoryInformer constructs a new informer for HelmRepository type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewHelmRepositoryInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredHelmRepositoryInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredHelmRepositoryInformer constructs a new informer for HelmRepository type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredHelmRepositoryInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.HelmV1().HelmRepositories(namespace).List(options)
+			},
+			WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.HelmV1().HelmRepositories(namespace).Watch(options)
+			},
+		},
+		&helm_bitnami_com_v1.HelmRepository{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *helmRepositoryInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredHelmRepositoryInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *helmRepositoryInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&helm_bitnami_com_v1.HelmRepository{}, f.defaultInformer)
+}
+
+func (f *helmRepositoryInformer) Lister() v1.HelmRepositoryLister {
+	return v1.NewHelmRepositoryLister(f.Informer().GetIndexer())
+}
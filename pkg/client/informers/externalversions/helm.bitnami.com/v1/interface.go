@@ -24,8 +24,12 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ClusterHelmRepositories returns a ClusterHelmRepositoryInformer.
+	ClusterHelmRepositories() ClusterHelmRepositoryInformer
 	// HelmReleases returns a HelmReleaseInformer.
 	HelmReleases() HelmReleaseInformer
+	// HelmRepositories returns a HelmRepositoryInformer.
+	HelmRepositories() HelmRepositoryInformer
 }
 
 type version struct {
@@ -39,7 +43,17 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ClusterHelmRepositories returns a ClusterHelmRepositoryInformer.
+func (v *version) ClusterHelmRepositories() ClusterHelmRepositoryInformer {
+	return &clusterHelmRepositoryInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // HelmReleases returns a HelmReleaseInformer.
 func (v *version) HelmReleases() HelmReleaseInformer {
 	return &helmReleaseInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// HelmRepositories returns a HelmRepositoryInformer.
+func (v *version) HelmRepositories() HelmRepositoryInformer {
+	return &helmRepositoryInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
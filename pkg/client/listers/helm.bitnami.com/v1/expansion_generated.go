@@ -18,6 +18,10 @@ limitations under the License.
 
 package v1
 
+// ClusterHelmRepositoryListerExpansion allows custom methods to be added to
+// ClusterHelmRepositoryLister.
+type ClusterHelmRepositoryListerExpansion interface{}
+
 // HelmReleaseListerExpansion allows custom methods to be added to
 // HelmReleaseLister.
 type HelmReleaseListerExpansion interface{}
@@ -25,3 +29,11 @@ type HelmReleaseListerExpansion interface{}
 // HelmReleaseNamespaceListerExpansion allows custom methods to be added to
 // HelmReleaseNamespaceLister.
 type HelmReleaseNamespaceListerExpansion interface{}
+
+// HelmRepositoryListerExpansion allows custom methods to be added to
+// HelmRepositoryLister.
+type HelmRepositoryListerExpansion interface{}
+
+// HelmRepositoryNamespaceListerExpansion allows custom methods to be added to
+// HelmRepositoryNamespaceLister.
+type HelmRepositoryNamespaceListerExpansion interface{}
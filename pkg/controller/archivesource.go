@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const digestPrefix = "sha256:"
+
+// archiveChart returns a source loading the chart archive data, which
+// was read from url
+func archiveChart(url string, data []byte) *chartSource {
+	s := &chartSource{
+		url:    url,
+		source: &v1.SourceStatus{URL: url, Revision: digestPrefix + archiveDigest(data)},
+	}
+	s.load = func() (*chart.Chart, error) {
+		return s.loadArchive(data)
+	}
+	return s
+}
+
+// tarballChart resolves the tarball source of hr. Pinned archives are
+// only downloaded once, while unpinned ones are downloaded every time to
+// detect changes.
+func (c *Controller) tarballChart(hr *v1.HelmRelease) (*chartSource, error) {
+	tarball := hr.Spec.Source.Tarball
+	if tarball.URL == "" {
+		return nil, fmt.Errorf("source.tarball.url is required")
+	}
+	creds := &repoCredentials{}
+	if ref := tarball.CredentialsSecretRef; ref != nil {
+		var err error
+		if creds, err = c.secretCredentials(hr.Namespace, ref.Name); err != nil {
+			return nil, err
+		}
+	}
+	g, err := chartGetter(tarball.URL, creds, creds.getters(tarball.URL, getter.All(c.config.Helm)))
+	if err != nil {
+		creds.cleanup()
+		return nil, err
+	}
+
+	if tarball.Digest == "" {
+		defer creds.cleanup()
+		data, err := downloadArchive(tarball.URL, tarball.URL, g)
+		if err != nil {
+			return nil, err
+		}
+		return archiveChart(tarball.URL, data), nil
+	}
+
+	digest := strings.ToLower(strings.TrimPrefix(tarball.Digest, digestPrefix))
+	s := &chartSource{
+		url:     tarball.URL,
+		source:  &v1.SourceStatus{URL: tarball.URL, Revision: digestPrefix + digest},
+		cleanup: creds.cleanup,
+	}
+	s.load = func() (*chart.Chart, error) {
+		key := chartCacheKey(tarball.URL, "", "", digest, creds.cacheKey())
+		data, err := c.fetchArchive(key, tarball.URL, tarball.URL, digest, g)
+		if err != nil {
+			return nil, err
+		}
+		return s.loadArchive(data)
+	}
+	return s, nil
+}
+
+// objectChart resolves the ConfigMap or Secret source of hr
+func (c *Controller) objectChart(hr *v1.HelmRelease) (*chartSource, error) {
+	src := hr.Spec.Source
+	if src.ConfigMapKeyRef != nil {
+		sel := src.ConfigMapKeyRef
+		url := fmt.Sprintf("configmap:%s/%s/%s", hr.Namespace, sel.Name, sel.Key)
+		obj, exists, err := c.configMapInformer.GetIndexer().GetByKey(hr.Namespace + "/" + sel.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("chart configmap %s/%s not found", hr.Namespace, sel.Name)
+		}
+		cm := obj.(*corev1.ConfigMap)
+		if data, ok := cm.BinaryData[sel.Key]; ok {
+			return archiveChart(url, data), nil
+		}
+		if data, ok := cm.Data[sel.Key]; ok {
+			return archiveChart(url, []byte(data)), nil
+		}
+		return nil, fmt.Errorf("key %q not found in chart configmap %s/%s", sel.Key, hr.Namespace, sel.Name)
+	}
+
+	sel := src.SecretKeyRef
+	url := fmt.Sprintf("secret:%s/%s/%s", hr.Namespace, sel.Name, sel.Key)
+	obj, exists, err := c.secretInformer.GetIndexer().GetByKey(hr.Namespace + "/" + sel.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("chart secret %s/%s not found", hr.Namespace, sel.Name)
+	}
+	data, ok := obj.(*corev1.Secret).Data[sel.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in chart secret %s/%s", sel.Key, hr.Namespace, sel.Name)
+	}
+	return archiveChart(url, data), nil
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"errors"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// ErrReleaseNotFound is returned by a ReleaseBackend asked about a
+// release it doesn't know
+var ErrReleaseNotFound = errors.New("release not found")
+
+// ReleaseBackend installs and manages the Helm releases of HelmReleases.
+// Releases are identified by their name and the namespace they were
+// installed into.
+type ReleaseBackend interface {
+	// Install installs chart as a new release
+	Install(chart *chart.Chart, opts InstallOptions) (*release.Release, error)
+	// Upgrade upgrades the release name to chart
+	Upgrade(namespace, name string, chart *chart.Chart, opts UpgradeOptions) (*release.Release, error)
+	// Delete uninstalls the release name
+	Delete(namespace, name string, opts DeleteOptions) error
+	// History returns up to max revisions of the release name, newest
+	// first
+	History(namespace, name string, max int32) ([]*release.Release, error)
+	// Status returns the status of the current revision of the release
+	// name
+	Status(namespace, name string) (*release.Status, error)
+	// Content returns the current revision of the release name, with
+	// its manifest
+	Content(namespace, name string) (*release.Release, error)
+	// Rollback rolls the release name back to a previous revision
+	Rollback(namespace, name string, opts RollbackOptions) (*release.Release, error)
+	// Test runs the tests of the release name
+	Test(namespace, name string, opts TestOptions) (*release.TestSuite, error)
+	// Ping checks that the backend can be reached
+	Ping() error
+}
+
+// releaseValidator is implemented by the ReleaseBackends not supporting
+// every setting of HelmReleases
+type releaseValidator interface {
+	// validateRelease returns an error if the release of hr can't be
+	// managed as specified
+	validateRelease(hr *v1.HelmRelease) error
+}
+
+// InstallOptions are the options of ReleaseBackend.Install
+type InstallOptions struct {
+	Name         string
+	Namespace    string
+	Values       []byte
+	Wait         bool
+	Timeout      int64
+	DisableHooks bool
+}
+
+// UpgradeOptions are the options of ReleaseBackend.Upgrade
+type UpgradeOptions struct {
+	Values       []byte
+	Force        bool
+	Recreate     bool
+	Wait         bool
+	Timeout      int64
+	DisableHooks bool
+}
+
+// DeleteOptions are the options of ReleaseBackend.Delete
+type DeleteOptions struct {
+	Purge        bool
+	Timeout      int64
+	DisableHooks bool
+}
+
+// RollbackOptions are the options of ReleaseBackend.Rollback
+type RollbackOptions struct {
+	Revision     int32
+	Timeout      int64
+	Wait         bool
+	Force        bool
+	Recreate     bool
+	DisableHooks bool
+}
+
+// TestOptions are the options of ReleaseBackend.Test
+type TestOptions struct {
+	Timeout int64
+	Cleanup bool
+	// Progress, if set, is called with the state of each test as it
+	// changes during the run
+	Progress func(run *release.TestRun)
+}
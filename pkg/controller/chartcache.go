@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// chartCacheKey identifies a chart archive by where it comes from and,
+// when the repository index provides it, by its digest. Archives of
+// private repositories are also keyed by the credentials they were
+// downloaded with, see repoCredentials.cacheKey, so that releases can't
+// get them from the cache without access to the repository.
+func chartCacheKey(repoURL, chartName, version, digest, credentials string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{repoURL, chartName, version, digest, credentials}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// sizedLRU tracks entries up to a total size, evicting the least
+// recently used ones beyond it. It is not safe for concurrent use.
+type sizedLRU struct {
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+	onEvict  func(e *lruEntry)
+}
+
+type lruEntry struct {
+	key  string
+	size int64
+	data []byte
+}
+
+func newSizedLRU(maxBytes int64, onEvict func(e *lruEntry)) *sizedLRU {
+	return &sizedLRU{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+		onEvict:  onEvict,
+	}
+}
+
+func (l *sizedLRU) get(key string) (*lruEntry, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return el.Value.(*lruEntry), true
+}
+
+// add adds an entry and reports whether it was kept, entries larger
+// than the whole cache are not
+func (l *sizedLRU) add(e *lruEntry) bool {
+	if e.size > l.maxBytes {
+		return false
+	}
+	if el, ok := l.items[e.key]; ok {
+		l.remove(el)
+	}
+	l.items[e.key] = l.ll.PushFront(e)
+	l.size += e.size
+	for l.size > l.maxBytes {
+		l.remove(l.ll.Back())
+	}
+	return true
+}
+
+func (l *sizedLRU) remove(el *list.Element) {
+	e := el.Value.(*lruEntry)
+	l.ll.Remove(el)
+	delete(l.items, e.key)
+	l.size -= e.size
+	if l.onEvict != nil {
+		l.onEvict(e)
+	}
+}
+
+// chartCache keeps chart archives in memory and in a directory on disk,
+// each bounded in size. Archives evicted from memory remain on disk
+// until evicted from there too. It is shared by all workers.
+type chartCache struct {
+	mu     sync.Mutex
+	memory *sizedLRU
+	disk   *sizedLRU
+	dir    string
+}
+
+// newChartCache returns a cache holding up to memBytes of archives in
+// memory and diskBytes in dir. Archives already in dir are picked up,
+// the most recently modified ones being kept. A zero size disables the
+// corresponding tier.
+func newChartCache(dir string, memBytes, diskBytes int64) (*chartCache, error) {
+	c := &chartCache{
+		memory: newSizedLRU(memBytes, nil),
+		dir:    dir,
+	}
+	c.disk = newSizedLRU(diskBytes, func(e *lruEntry) {
+		if err := os.Remove(c.path(e.key)); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("Unable to remove cached chart %s: %v", c.path(e.key), err)
+		}
+	})
+	if diskBytes <= 0 {
+		return c, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, fi := range files {
+		key := strings.TrimSuffix(fi.Name(), ".tgz")
+		if fi.IsDir() || key == fi.Name() {
+			continue
+		}
+		if !c.disk.add(&lruEntry{key: key, size: fi.Size()}) {
+			os.Remove(c.path(key))
+		}
+	}
+	return c, nil
+}
+
+func (c *chartCache) path(key string) string {
+	return filepath.Join(c.dir, key+".tgz")
+}
+
+// Get returns the archive cached under key, if any
+func (c *chartCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.memory.get(key); ok {
+		c.disk.get(key)
+		return e.data, true
+	}
+	if _, ok := c.disk.get(key); !ok {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		glog.Warningf("Unable to read cached chart %s: %v", c.path(key), err)
+		c.disk.remove(c.disk.items[key])
+		return nil, false
+	}
+	c.memory.add(&lruEntry{key: key, size: int64(len(data)), data: data})
+	return data, true
+}
+
+// Add caches the archive data under key
+func (c *chartCache) Add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := int64(len(data))
+	c.memory.add(&lruEntry{key: key, size: size, data: data})
+	if _, ok := c.disk.get(key); ok || size > c.disk.maxBytes {
+		return
+	}
+	if err := ioutil.WriteFile(c.path(key), data, 0644); err != nil {
+		glog.Warningf("Unable to cache chart to %s: %v", c.path(key), err)
+		return
+	}
+	c.disk.add(&lruEntry{key: key, size: size})
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestChartCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, b, c := bytes.Repeat([]byte("a"), 40), bytes.Repeat([]byte("b"), 40), bytes.Repeat([]byte("c"), 40)
+	cache, err := newChartCache(dir, 50, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Add("a", a)
+	cache.Add("b", b)
+	if _, ok := cache.memory.get("a"); ok {
+		t.Errorf("a is still in memory after b was added")
+	}
+	if got, ok := cache.Get("a"); !ok || !bytes.Equal(got, a) {
+		t.Errorf("a was not read back from disk")
+	}
+
+	// a was used last, so b is evicted from disk
+	cache.Add("c", c)
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("b is still cached after c was added")
+	}
+	if _, err := os.Stat(cache.path("b")); !os.IsNotExist(err) {
+		t.Errorf("b is still on disk: %v", err)
+	}
+
+	reloaded, err := newChartCache(dir, 50, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string][]byte{"a": a, "c": c} {
+		if got, ok := reloaded.Get(key); !ok || !bytes.Equal(got, want) {
+			t.Errorf("%s was not reloaded from disk", key)
+		}
+	}
+}
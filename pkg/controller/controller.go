@@ -4,45 +4,86 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strings"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
-	"google.golang.org/grpc"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
 	extclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery/cached"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/helm/pkg/chartutil"
-	"k8s.io/helm/pkg/downloader"
-	"k8s.io/helm/pkg/getter"
-	"k8s.io/helm/pkg/helm"
-	"k8s.io/helm/pkg/proto/hapi/release"
-	"k8s.io/helm/pkg/repo"
 
 	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
 	"github.com/fengxsong/helm-crd/pkg/client/clientset/versioned"
+	helmscheme "github.com/fengxsong/helm-crd/pkg/client/clientset/versioned/scheme"
 	informers "github.com/fengxsong/helm-crd/pkg/client/informers/externalversions"
 )
 
 const (
 	controllerName = "HelmReleases-controller"
 	maxRetries     = 5
+
+	tillerPingPeriod = 30 * time.Second
 )
 
 // Controller is a cache.Controller for acting on Helm CRD objects
 type Controller struct {
+	config        Config
 	kubeClientset kubernetes.Interface
 	clientset     versioned.Interface
-	helmClient    *helm.Client
+	backend       ReleaseBackend
 	informer      cache.SharedIndexInformer
 	queue         workqueue.RateLimitingInterface
+	recorder      record.EventRecorder
+
+	repoInformer        cache.SharedIndexInformer
+	clusterRepoInformer cache.SharedIndexInformer
+	repoQueue           workqueue.RateLimitingInterface
+
+	lastWarningsLock sync.Mutex
+	lastWarnings     map[string]string
+
+	operationsLock sync.Mutex
+	operations     map[string]*operation
+
+	configMapInformer cache.SharedIndexInformer
+	secretInformer    cache.SharedIndexInformer
+
+	healthInformers map[string]cache.SharedIndexInformer
+	healthQueue     workqueue.RateLimitingInterface
+	health          *healthTracker
+
+	charts    *chartCache
+	indexes   *repoIndexCache
+	resources liveResources
+
+	// registry holds the metrics of this controller, served along with
+	// the global ones
+	registry *prometheus.Registry
+
+	// gitSchemes are the schemes of the Git repositories that may be
+	// fetched, see defaultGitSchemes
+	gitSchemes []string
 }
 
-// NewController creates a Controller
-func NewController() (*Controller, error) {
+// NewController creates a Controller with the given settings, talking to
+// the Kubernetes API server described by kubeconfig
+func NewController(kubeconfig *rest.Config, config Config) (*Controller, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	kubeClientset, err := kubernetes.NewForConfig(kubeconfig)
 	if err != nil {
 		return nil, err
@@ -58,20 +99,91 @@ func NewController() (*Controller, error) {
 		return nil, err
 	}
 
-	if err = ensureCustomResource(extClientset); err != nil {
+	if err = ensureCustomResources(extClientset); err != nil {
 		return nil, err
 	}
 
-	crdInformersFactory := informers.NewSharedInformerFactory(clientset, time.Second*time.Duration(resyncDuration))
+	var backend ReleaseBackend
+	switch config.Backend {
+	case "tiller":
+		glog.Infof("Using tiller host: %s", config.Helm.TillerHost)
+		backend = newTillerBackend(config.Helm.TillerHost)
+	case "helm3":
+		glog.Info("Using the helm3 backend, release records are kept in the release namespaces")
+		backend = newHelm3Backend(kubeconfig, kubeClientset, config.Helm.TillerNamespace)
+	default:
+		return nil, fmt.Errorf("unknown release backend %q", config.Backend)
+	}
 
-	glog.Infof("Using tiller host: %s", settings.TillerHost)
+	c, err := newController(kubeClientset, clientset, backend, config)
+	if err != nil {
+		return nil, err
+	}
+
+	c.resources = newDynamicResources(kubeconfig, cached.NewMemCacheClient(kubeClientset.Discovery()))
+
+	return c, nil
+}
+
+// newController creates a Controller using the given clients, without
+// touching the API server
+func newController(kubeClientset kubernetes.Interface, clientset versioned.Interface, backend ReleaseBackend, config Config) (*Controller, error) {
+	crdInformersFactory := informers.NewSharedInformerFactory(clientset, time.Second*time.Duration(config.Resync))
+
+	cacheDir := config.ChartCacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(config.Helm.Home.Cache(), "charts")
+	}
+	charts, err := newChartCache(cacheDir, config.ChartCacheMemoryMB<<20, config.ChartCacheDiskMB<<20)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up the chart cache: %v", err)
+	}
+
+	// Register HelmRelease so events can reference it
+	helmscheme.AddToScheme(scheme.Scheme)
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClientset.CoreV1().Events("")})
 
 	c := &Controller{
+		config:        config,
 		kubeClientset: kubeClientset,
 		clientset:     clientset,
-		helmClient:    helm.NewClient(helm.Host(settings.TillerHost), helm.ConnectTimeout(5)),
+		backend:       backend,
 		informer:      crdInformersFactory.Helm().V1().HelmReleases().Informer(),
-		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), ""),
+		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "helmreleases"),
+		recorder:      eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerName}),
+		lastWarnings:  map[string]string{},
+		operations:    map[string]*operation{},
+
+		repoInformer:        crdInformersFactory.Helm().V1().HelmRepositories().Informer(),
+		clusterRepoInformer: crdInformersFactory.Helm().V1().ClusterHelmRepositories().Informer(),
+		repoQueue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "helmrepositories"),
+
+		configMapInformer: newCoreInformer(kubeClientset.CoreV1().RESTClient(), "configmaps", &corev1.ConfigMap{}),
+		secretInformer:    newCoreInformer(kubeClientset.CoreV1().RESTClient(), "secrets", &corev1.Secret{}),
+
+		healthInformers: newHealthInformers(kubeClientset),
+		healthQueue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "health"),
+		health:          newHealthTracker(),
+
+		charts:   charts,
+		indexes:  newRepoIndexCache(config.RepoIndexRefresh),
+		registry: prometheus.NewRegistry(),
+
+		gitSchemes: defaultGitSchemes,
+	}
+
+	if err := c.registry.Register(releasePhaseCollector{c.informer}); err != nil {
+		return nil, err
+	}
+
+	if err := c.informer.AddIndexers(cache.Indexers{
+		valuesFromIndex: valuesFromIndexFunc,
+		repositoryIndex: repositoryIndexFunc,
+		dependsOnIndex:  dependsOnIndexFunc,
+	}); err != nil {
+		return nil, err
 	}
 
 	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -80,13 +192,30 @@ func NewController() (*Controller, error) {
 		DeleteFunc: c.onDeleteFunc,
 	})
 
+	c.configMapInformer.AddEventHandler(c.valuesSourceEventHandler(kindConfigMap))
+	c.secretInformer.AddEventHandler(c.valuesSourceEventHandler(kindSecret))
+	c.repoInformer.AddEventHandler(c.repositoryEventHandler(kindHelmRepository))
+	c.clusterRepoInformer.AddEventHandler(c.repositoryEventHandler(kindClusterHelmRepository))
+	for kind, informer := range c.healthInformers {
+		informer.AddEventHandler(c.healthEventHandler(kind))
+	}
+
 	return c, nil
 }
 
 // HasSynced returns true once this controller has completed an
 // initial resource listing
 func (c *Controller) HasSynced() bool {
-	return c.informer.HasSynced()
+	for _, informer := range c.healthInformers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return c.informer.HasSynced() &&
+		c.configMapInformer.HasSynced() &&
+		c.secretInformer.HasSynced() &&
+		c.repoInformer.HasSynced() &&
+		c.clusterRepoInformer.HasSynced()
 }
 
 // LastSyncResourceVersion is the resource version observed when last
@@ -103,17 +232,21 @@ func (c *Controller) LastSyncResourceVersion() string {
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	defer runtime.HandleCrash()
 	defer c.queue.ShutDown()
+	defer c.repoQueue.ShutDown()
+	defer c.healthQueue.ShutDown()
 
 	go c.informer.Run(stopCh)
+	go c.configMapInformer.Run(stopCh)
+	go c.secretInformer.Run(stopCh)
+	go c.repoInformer.Run(stopCh)
+	go c.clusterRepoInformer.Run(stopCh)
+	for _, informer := range c.healthInformers {
+		go informer.Run(stopCh)
+	}
 	// Start the informer factories to begin populating the informer caches
 	glog.Infof("Starting %s", controllerName)
 
-	// Set up a helm home dir sufficient to fool the rest of helm
-	// client code
-	os.MkdirAll(settings.Home.Archive(), 0755)
-	os.MkdirAll(settings.Home.Repository(), 0755)
-	ioutil.WriteFile(settings.Home.RepositoryFile(),
-		[]byte("apiVersion: v1\nrepositories: []"), 0644)
+	c.setupHelmHome()
 
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
@@ -124,11 +257,30 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	}
 	glog.Info("Cache synchronised, starting main loop")
 
-	wait.Until(c.runWorker, time.Second, stopCh)
+	go wait.Until(func() { c.backend.Ping() }, tillerPingPeriod, stopCh)
+
+	glog.Infof("Starting %d workers", c.config.Workers)
+	for i := 0; i < c.config.Workers; i++ {
+		// The workqueue never hands the same key to two workers at once
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+	go wait.Until(c.runRepositoryWorker, time.Second, stopCh)
+	go wait.Until(c.runHealthWorker, time.Second, stopCh)
+	<-stopCh
 
 	glog.Infof("Shutting down %s", controllerName)
 }
 
+// setupHelmHome sets up a helm home dir sufficient to fool the rest of
+// helm client code
+func (c *Controller) setupHelmHome() {
+	home := c.config.Helm.Home
+	os.MkdirAll(home.Archive(), 0755)
+	os.MkdirAll(home.Repository(), 0755)
+	ioutil.WriteFile(home.RepositoryFile(),
+		[]byte("apiVersion: v1\nrepositories: []"), 0644)
+}
+
 func (c *Controller) runWorker() {
 	for c.processNextItem() {
 		// continue looping
@@ -144,9 +296,16 @@ func (c *Controller) processNextItem() bool {
 	defer c.queue.Done(key)
 
 	// should we deal with non-string error?
+	start := time.Now()
 	err := c.updateRelease(key.(string))
+	observeReconcile(start, err)
 	if err == nil {
-		c.queue.Forget(key)
+		// The requeues of keys are kept while their operation runs in
+		// the background, so that failing operations are retried
+		// maxRetries times in all
+		if c.pendingOperation(key.(string)) == nil {
+			c.queue.Forget(key)
+		}
 	} else if c.queue.NumRequeues(key) < maxRetries {
 		glog.Errorf("Error updating %s, will retry: %v", key, err)
 		c.queue.AddRateLimited(key)
@@ -165,12 +324,6 @@ func releaseName(ns, name string) string {
 	return fmt.Sprintf("%s-%s", ns, name)
 }
 
-func isNotFound(err error) bool {
-	// Ideally this would be `grpc.Code(err) == codes.NotFound`,
-	// but it seems helm doesn't return grpc codes
-	return strings.Contains(grpc.ErrorDesc(err), "not found")
-}
-
 func (c *Controller) updateRelease(key string) error {
 	obj, exists, err := c.informer.GetIndexer().GetByKey(key)
 	if err != nil {
@@ -178,112 +331,136 @@ func (c *Controller) updateRelease(key string) error {
 	}
 
 	if !exists {
-		glog.Infof("HelmRelease %s has gone, uninstalling chart", key)
-		ns, name, err := cache.SplitMetaNamespaceKey(key)
+		// Releases are uninstalled before the release finalizer is
+		// removed, see finalizeRelease
+		glog.Infof("HelmRelease %s has gone", key)
+		c.forgetWarnings(key)
+		return nil
+	}
+
+	helmObj := obj.(*v1.HelmRelease)
+	// Installs/upgrades waiting in the background requeue the
+	// HelmRelease once done, deletion included
+	if op, running := c.finishedOperation(key); running {
+		glog.Infof("HelmRelease %s is being deployed, waiting", key)
+		return nil
+	} else if op != nil && helmObj.DeletionTimestamp == nil {
+		// The lister may not have seen the test results recorded while
+		// the operation ran yet
+		latest, err := c.clientset.HelmV1().HelmReleases(helmObj.Namespace).Get(helmObj.Name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			glog.Warningf("Unable to get HelmRelease %s, using the cached one: %v", key, err)
+			latest = helmObj.DeepCopy()
 		}
-		_, err = c.helmClient.DeleteRelease(
-			releaseName(ns, name),
-			helm.DeletePurge(true),
-		)
-		if err != nil {
+		err = c.finishOperation(key, latest, op)
+		if err, ok := err.(*wrapError); ok {
+			// Leave the Deploying phase until the operation is retried
+			c.handleWrapError(err)
+		}
+		return err
+	}
+	if helmObj.DeletionTimestamp != nil {
+		return c.finalizeRelease(key, helmObj)
+	}
+	if !hasFinalizer(helmObj) {
+		if helmObj, err = c.addFinalizer(helmObj); err != nil {
 			return err
 		}
-		return nil
 	}
-
-	helmObj := obj.(*v1.HelmRelease)
 	if helmObj.Spec.Paused {
 		glog.Infof("HelmRelease %s is not yet process", helmObj.Name)
 		return nil
 	}
-	// FIXME: make configurable
-	keyring := "/keyring/pubring.gpg"
 
-	dl := downloader.ChartDownloader{
-		HelmHome: settings.Home,
-		Out:      os.Stdout,
-		Keyring:  keyring,
-		Getters:  getter.All(settings),
-		Verify:   downloader.VerifyNever, // FIXME
+	stored := helmObj
+	upToDate := helmObj.Status.Phase == v1.HelmRealeasePhaseReady &&
+		helmObj.Status.ObservedGeneration == helmObj.Generation
+
+	helmObj = helmObj.DeepCopy()
+	now := metav1.Now()
+	if helmObj.Status.ObservedGeneration != helmObj.Generation {
+		helmObj.Status.Failures = 0
+	}
+	helmObj.Status.ObservedGeneration = helmObj.Generation
+	helmObj.Status.LastAttemptTime = &now
+
+	if v, ok := c.backend.(releaseValidator); ok {
+		if err := v.validateRelease(helmObj); err != nil {
+			return &wrapError{helmObj, err}
+		}
 	}
 
-	repoURL := helmObj.Spec.RepoURL
-	if repoURL == "" {
-		repoURL = defaultRepoURL
+	if len(helmObj.Spec.DependsOn) > 0 {
+		waiting, err := c.waitForDependencies(key, helmObj)
+		if err != nil {
+			return err
+		}
+		if waiting {
+			return nil
+		}
 	}
 
-	// FIXME: Make configurable
-	certFile := ""
-	keyFile := ""
-	caFile := ""
-	chartURL, err := repo.FindChartInAuthRepoURL(repoURL, helmObj.Spec.Username, helmObj.Spec.Password, helmObj.Spec.ChartName, helmObj.Spec.Version, certFile, keyFile, caFile, getter.All(settings))
+	values, err := c.composeValues(helmObj)
 	if err != nil {
 		return &wrapError{helmObj, err}
 	}
 
-	glog.Infof("Downloading %s ...", chartURL)
-	fname, _, err := dl.DownloadTo(chartURL, helmObj.Spec.Version, settings.Home.Archive())
+	// Polling goes on while the chart source is unavailable
+	poll := pollsChart(helmObj)
+	if poll {
+		defer c.queue.AddAfter(key, c.pollInterval(helmObj))
+	}
+	src, err := c.resolveChart(key, helmObj)
 	if err != nil {
 		return &wrapError{helmObj, err}
 	}
-	glog.Infof("Downloaded %s to %s", chartURL, fname)
-	chartRequested, err := chartutil.LoadFile(fname) // fixme: just download to ram buf
+	if src.cleanup != nil {
+		defer src.cleanup()
+	}
+	if upToDate && src.unchanged(&helmObj.Status) &&
+		valuesHash(values) == helmObj.Status.LastAppliedValuesHash {
+		glog.Infof("HelmRelease %s is up to date (version %s)", key, src.revision())
+		c.forgetWarnings(key)
+		return c.checkDrift(key, stored)
+	}
+
+	chartRequested, err := src.load()
 	if err != nil {
-		glog.Errorf("Error loading chart file: %v", err)
+		glog.Errorf("Error loading chart %s: %v", src.url, err)
+		setCondition(&helmObj.Status, v1.HelmReleaseConditionDownloaded, corev1.ConditionFalse, reasonDownloadFailed, err.Error())
+		c.warningEventf(key, helmObj, reasonDownloadFailed, "Failed to download %s: %v", src.url, err)
 		return &wrapError{helmObj, err}
 	}
+	setCondition(&helmObj.Status, v1.HelmReleaseConditionDownloaded, corev1.ConditionTrue, reasonChartDownloaded, src.url)
+	c.recorder.Eventf(helmObj, corev1.EventTypeNormal, reasonChartDownloaded, "Downloaded chart %s", src.url)
 
-	rlsName := releaseName(helmObj.Namespace, helmObj.Name)
-
-	var rel *release.Release
-	_, err = c.helmClient.ReleaseHistory(rlsName, helm.WithMaxHistory(1))
-	if err != nil {
-		if !isNotFound(err) {
-			glog.Errorf("Error getting release history: %v", err)
-			return &wrapError{helmObj, err}
-		}
-		glog.Infof("Installing release %s into namespace %s", rlsName, helmObj.Namespace)
-		res, err := c.helmClient.InstallReleaseFromChart(
-			chartRequested,
-			helmObj.Namespace,
-			helm.ValueOverrides([]byte(helmObj.Spec.RawValues)),
-			helm.ReleaseName(rlsName),
-		)
-		if err != nil {
-			return &wrapError{helmObj, err}
-		}
-		rel = res.GetRelease()
-	} else {
-		glog.Infof("Update release %s with options UpgradeForce(%v)/UpgradeRecreate(%v)",
-			rlsName, helmObj.Spec.Force, helmObj.Spec.Recreate)
-		res, err := c.helmClient.UpdateReleaseFromChart(
-			rlsName,
-			chartRequested,
-			helm.UpdateValueOverrides([]byte(helmObj.Spec.RawValues)),
-			helm.UpgradeForce(helmObj.Spec.Force),
-			helm.UpgradeRecreate(helmObj.Spec.Recreate),
-		)
-		if err != nil {
+	if src.verify != nil {
+		if err := src.verify(); err != nil {
 			return &wrapError{helmObj, err}
 		}
-		rel = res.GetRelease()
-	}
-
-	status, err := c.helmClient.ReleaseStatus(rel.Name)
-	if err == nil {
-		glog.Infof("Installed/updated release %s, version %d (status %s)", rel.Name, rel.Version, status.Info.Status.Code)
-	} else {
-		glog.Warningf("Unable to fetch release status for %s: %v", rel.Name, err)
 	}
-	helmObjCopy := helmObj.DeepCopy()
+	helmObj.Status.LastAttemptedVersion = chartRequested.GetMetadata().GetVersion()
 
-	helmObjCopy.Status.ChartURL = chartURL
-	helmObjCopy.Status.Revision = rel.GetVersion()
-	helmObjCopy.Status.Phase = v1.HelmRealeasePhaseReady
-	if _, err := c.clientset.HelmV1().HelmReleases(helmObjCopy.Namespace).Update(helmObjCopy); err != nil {
+	rlsName := releaseName(helmObj.Namespace, helmObj.Name)
+	_, err = c.backend.History(helmObj.Namespace, rlsName, 1)
+	if err != nil && err != ErrReleaseNotFound {
+		glog.Errorf("Error getting release history: %v", err)
 		return &wrapError{helmObj, err}
 	}
-	return nil
+	op := &operation{
+		install:          err == ErrReleaseNotFound,
+		generation:       helmObj.Generation,
+		url:              src.url,
+		version:          src.version,
+		source:           src.source,
+		poll:             poll,
+		chartVersion:     helmObj.Status.LastAttemptedVersion,
+		valuesHash:       valuesHash(values),
+		lastGoodRevision: lastGoodRevision(helmObj),
+	}
+	if runsInBackground(helmObj) {
+		return c.runInBackground(key, op, helmObj, chartRequested, values)
+	}
+	c.runOperation(op, helmObj, chartRequested, values)
+	return c.finishOperation(key, helmObj, op)
 }
@@ -0,0 +1,1169 @@
+package controller
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/helm/helmpath"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/repo"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+	"github.com/fengxsong/helm-crd/pkg/client/clientset/versioned/fake"
+)
+
+const (
+	testNamespace = "default"
+	testName      = "myrelease"
+	testRelease   = testNamespace + "-" + testName
+)
+
+// newTestRepo serves a chart repository holding version 1.0.0 of the
+// chart "mychart" from dir
+func newTestRepo(t *testing.T, dir string) *httptest.Server {
+	repoDir := filepath.Join(dir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "mychart", Version: "1.0.0"},
+		Values:   &chart.Config{Raw: "replicas: 1\n"},
+	}
+	if _, err := chartutil.Save(ch, repoDir); err != nil {
+		t.Fatal(err)
+	}
+	index, err := repo.IndexDirectory(repoDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.WriteFile(filepath.Join(repoDir, "index.yaml"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.FileServer(http.Dir(repoDir)))
+}
+
+func newTestRelease(repoURL string) *v1.HelmRelease {
+	return &v1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       testName,
+			Namespace:  testNamespace,
+			Generation: 1,
+		},
+		Spec: v1.HelmReleaseSpec{
+			RepoURL:   repoURL,
+			ChartName: "mychart",
+			Version:   "1.0.0",
+			RawValues: "replicas: 2\n",
+		},
+	}
+}
+
+func deployedRelease() []*release.Release {
+	return []*release.Release{{
+		Name:      testRelease,
+		Namespace: testNamespace,
+		Version:   1,
+		Config:    &chart.Config{},
+		Info:      &release.Info{Status: &release.Status{Code: release.Status_DEPLOYED}},
+	}}
+}
+
+// newTestController returns a controller releasing with backend, whose
+// clientset and informers hold objs
+func newTestController(t *testing.T, backend ReleaseBackend, objs ...runtime.Object) (*Controller, *fake.Clientset) {
+	var helmObjs []runtime.Object
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *v1.HelmRelease, *v1.HelmRepository, *v1.ClusterHelmRepository:
+			helmObjs = append(helmObjs, obj)
+		}
+	}
+	clientset := fake.NewSimpleClientset(helmObjs...)
+	config := DefaultConfig()
+	config.Helm.Home = helmpath.Home(t.TempDir())
+	c, err := newController(kubefake.NewSimpleClientset(), clientset, backend, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.recorder = record.NewFakeRecorder(100)
+	for _, obj := range objs {
+		var informer cache.SharedIndexInformer
+		switch obj.(type) {
+		case *v1.HelmRelease:
+			informer = c.informer
+		case *v1.HelmRepository:
+			informer = c.repoInformer
+		case *v1.ClusterHelmRepository:
+			informer = c.clusterRepoInformer
+		case *corev1.ConfigMap:
+			informer = c.configMapInformer
+		case *corev1.Secret:
+			informer = c.secretInformer
+		case *corev1.PersistentVolumeClaim:
+			informer = c.healthInformers[kindPVC]
+		case *appsv1.Deployment:
+			informer = c.healthInformers[kindDeployment]
+		default:
+			t.Fatalf("unexpected test object %T", obj)
+		}
+		informer.GetIndexer().Add(obj)
+	}
+	return c, clientset
+}
+
+func TestUpdateRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	failure := errors.New("boom")
+	now := metav1.Now()
+
+	tests := []struct {
+		name string
+		// mutate adjusts the HelmRelease of the test
+		mutate   func(hr *v1.HelmRelease)
+		releases map[string][]*release.Release
+		errors   map[string]error
+
+		wantErr       bool
+		wantCalls     []string
+		wantPhase     v1.HelmRealeasePhase
+		wantRevision  int32
+		wantFinalizer bool
+		wantReleases  int
+	}{
+		{
+			name:          "installs new release",
+			wantCalls:     []string{"History", "Install", "Status"},
+			wantPhase:     v1.HelmRealeasePhaseReady,
+			wantRevision:  1,
+			wantFinalizer: true,
+			wantReleases:  1,
+		},
+		{
+			name: "upgrades existing release",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.Generation = 2
+				hr.Status.Phase = v1.HelmRealeasePhaseReady
+				hr.Status.ObservedGeneration = 1
+			},
+			releases:      map[string][]*release.Release{testRelease: deployedRelease()},
+			wantCalls:     []string{"History", "Upgrade", "Status"},
+			wantPhase:     v1.HelmRealeasePhaseReady,
+			wantRevision:  2,
+			wantFinalizer: true,
+			wantReleases:  1,
+		},
+		{
+			name: "skips up to date release",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.Status.Phase = v1.HelmRealeasePhaseReady
+				hr.Status.ObservedGeneration = 1
+				hr.Status.Revision = 1
+				hr.Status.ResolvedVersion = "1.0.0"
+				hr.Status.LastAppliedValuesHash = valuesHash([]byte(hr.Spec.RawValues))
+			},
+			releases:      map[string][]*release.Release{testRelease: deployedRelease()},
+			wantPhase:     v1.HelmRealeasePhaseReady,
+			wantRevision:  1,
+			wantFinalizer: true,
+			wantReleases:  1,
+		},
+		{
+			name: "skips paused release",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.Spec.Paused = true
+			},
+			wantFinalizer: true,
+		},
+		{
+			name: "fails without provenance when verification is required",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.Spec.Verify = v1.VerifyAlways
+			},
+			wantErr:       true,
+			wantFinalizer: true,
+		},
+		{
+			name:          "installs unsigned chart if verification is possible",
+			mutate:        func(hr *v1.HelmRelease) { hr.Spec.Verify = v1.VerifyIfPossible },
+			wantCalls:     []string{"History", "Install", "Status"},
+			wantPhase:     v1.HelmRealeasePhaseReady,
+			wantRevision:  1,
+			wantFinalizer: true,
+			wantReleases:  1,
+		},
+		{
+			name:          "fails to install",
+			errors:        map[string]error{"Install": failure},
+			wantErr:       true,
+			wantCalls:     []string{"History", "Install"},
+			wantFinalizer: true,
+		},
+		{
+			name: "fails to upgrade without rollback",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+			},
+			releases:      map[string][]*release.Release{testRelease: deployedRelease()},
+			errors:        map[string]error{"Upgrade": failure},
+			wantErr:       true,
+			wantCalls:     []string{"History", "Upgrade"},
+			wantFinalizer: true,
+			wantReleases:  1,
+		},
+		{
+			name: "rolls back failed upgrade",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.Spec.Rollback = &v1.RollbackSpec{Enable: true}
+				hr.Status.LastGoodRevision = 1
+			},
+			releases:      map[string][]*release.Release{testRelease: deployedRelease()},
+			errors:        map[string]error{"Upgrade": failure},
+			wantCalls:     []string{"History", "Upgrade", "Rollback"},
+			wantPhase:     v1.HelmRealeasePhaseRolledBack,
+			wantRevision:  2,
+			wantFinalizer: true,
+			wantReleases:  1,
+		},
+		{
+			name: "rolls back to last deployed revision of history",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.Spec.Rollback = &v1.RollbackSpec{Enable: true}
+			},
+			releases:      map[string][]*release.Release{testRelease: deployedRelease()},
+			errors:        map[string]error{"Upgrade": failure},
+			wantCalls:     []string{"History", "Upgrade", "History", "Rollback"},
+			wantPhase:     v1.HelmRealeasePhaseRolledBack,
+			wantRevision:  2,
+			wantFinalizer: true,
+			wantReleases:  1,
+		},
+		{
+			name: "purges release of deleted HelmRelease",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.DeletionTimestamp = &now
+			},
+			releases:  map[string][]*release.Release{testRelease: deployedRelease()},
+			wantCalls: []string{"Delete"},
+		},
+		{
+			name: "orphans release of deleted HelmRelease",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.DeletionTimestamp = &now
+				hr.Spec.DeletionPolicy = v1.DeletionPolicyOrphan
+			},
+			releases:     map[string][]*release.Release{testRelease: deployedRelease()},
+			wantReleases: 1,
+		},
+		{
+			name: "deletes gone release",
+			mutate: func(hr *v1.HelmRelease) {
+				hr.Finalizers = []string{v1.ReleaseFinalizer}
+				hr.DeletionTimestamp = &now
+			},
+			wantCalls: []string{"Delete"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease(server.URL)
+			if tt.mutate != nil {
+				tt.mutate(hr)
+			}
+
+			backend := NewFakeBackend()
+			for name, revs := range tt.releases {
+				backend.Releases[name] = revs
+			}
+			for method, err := range tt.errors {
+				backend.Errors[method] = err
+			}
+			c, clientset := newTestController(t, backend, hr)
+
+			err = c.updateRelease(testNamespace + "/" + testName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateRelease() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := backend.Methods(); !reflect.DeepEqual(got, tt.wantCalls) && len(got)+len(tt.wantCalls) > 0 {
+				t.Errorf("backend calls = %v, want %v", got, tt.wantCalls)
+			}
+			if got := len(backend.Releases); got != tt.wantReleases {
+				t.Errorf("backend has %d releases, want %d", got, tt.wantReleases)
+			}
+
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %q, want %q", got.Status.Phase, tt.wantPhase)
+			}
+			if got.Status.Revision != tt.wantRevision {
+				t.Errorf("revision = %d, want %d", got.Status.Revision, tt.wantRevision)
+			}
+			if hasFinalizer(got) != tt.wantFinalizer {
+				t.Errorf("finalizer present = %v, want %v", hasFinalizer(got), tt.wantFinalizer)
+			}
+		})
+	}
+}
+
+func TestUpdateReleasePassesValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	hr := newTestRelease(server.URL)
+	hr.Spec.Force = true
+	backend := NewFakeBackend()
+	backend.Releases[testRelease] = deployedRelease()
+	c, _ := newTestController(t, backend, hr)
+
+	if err := c.updateRelease(testNamespace + "/" + testName); err != nil {
+		t.Fatal(err)
+	}
+	want := UpgradeOptions{Values: []byte("replicas: 2\n"), Force: true, Timeout: defaultTimeout}
+	for _, call := range backend.Calls {
+		if call.Method != "Upgrade" {
+			continue
+		}
+		if !reflect.DeepEqual(call.Options, want) {
+			t.Errorf("upgrade options = %+v, want %+v", call.Options, want)
+		}
+		return
+	}
+	t.Errorf("release was not upgraded, calls: %v", backend.Methods())
+}
+
+func TestUpdateReleaseFromRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	hr := newTestRelease("")
+	hr.Spec.RepositoryRef = &v1.RepositoryReference{Name: "charts"}
+	repo := &v1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "charts", Generation: 1},
+		Spec:       v1.HelmRepositorySpec{URL: server.URL},
+	}
+	backend := NewFakeBackend()
+	c, clientset := newTestController(t, backend, hr)
+
+	if err := c.updateRelease(testNamespace + "/" + testName); err == nil {
+		t.Fatalf("updateRelease() succeeded without the HelmRepository")
+	}
+
+	if _, err := clientset.HelmV1().HelmRepositories(testNamespace).Create(repo); err != nil {
+		t.Fatal(err)
+	}
+	c.repoInformer.GetIndexer().Add(repo)
+	if err := c.updateRelease(testNamespace + "/" + testName); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.Releases) != 1 {
+		t.Errorf("release was not installed, calls: %v", backend.Methods())
+	}
+
+	key := repositoryKey(kindHelmRepository, testNamespace, "charts")
+	if err := c.syncRepository(key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := clientset.HelmV1().HelmRepositories(testNamespace).Get("charts", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Status.Ready || got.Status.Charts != 1 || got.Status.ObservedGeneration != 1 {
+		t.Errorf("repository status = %+v, want ready with 1 chart", got.Status)
+	}
+}
+
+// newTestGitRepo creates a Git repository holding the chart "mychart" in
+// the charts/mychart directory, and returns its URL and commit
+func newTestGitRepo(t *testing.T, dir string) (string, string) {
+	repoDir := filepath.Join(dir, "git")
+	chartDir := filepath.Join(repoDir, "charts", "mychart")
+	if err := os.MkdirAll(chartDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"Chart.yaml":  "name: mychart\nversion: 1.1.0\n",
+		"values.yaml": "replicas: 1\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(chartDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) string {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "Add mychart")
+	git("tag", "-a", "v1.1.0", "-m", "v1.1.0")
+	return "file://" + repoDir, git("rev-parse", "HEAD")
+}
+
+func TestUpdateReleaseFromGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repoURL, commit := newTestGitRepo(t, dir)
+
+	for _, ref := range []*v1.GitReference{nil, {Tag: "v1.1.0"}, {Commit: commit}} {
+		hr := newTestRelease("")
+		hr.Spec.Source = &v1.ChartSource{Git: &v1.GitSource{URL: repoURL, Ref: ref, Path: "charts/mychart"}}
+		backend := NewFakeBackend()
+		c, clientset := newTestController(t, backend, hr)
+		c.gitSchemes = append([]string{"file"}, defaultGitSchemes...)
+
+		if err := c.updateRelease(testNamespace + "/" + testName); err != nil {
+			t.Fatalf("ref %+v: %v", ref, err)
+		}
+		got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &v1.SourceStatus{URL: repoURL, Revision: commit}
+		if !reflect.DeepEqual(got.Status.Source, want) || got.Status.ResolvedVersion != "1.1.0" {
+			t.Errorf("ref %+v: source = %+v, version %q, want %+v, version 1.1.0", ref, got.Status.Source, got.Status.ResolvedVersion, want)
+		}
+
+		// The release is left alone until the ref moves
+		backend.Calls = nil
+		c.informer.GetIndexer().Update(got)
+		if err := c.updateRelease(testNamespace + "/" + testName); err != nil {
+			t.Fatal(err)
+		}
+		if len(backend.Calls) != 0 {
+			t.Errorf("ref %+v: up to date release was reconciled again: %v", ref, backend.Methods())
+		}
+	}
+}
+
+func TestUpdateReleaseFromArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	archive, err := ioutil.ReadFile(filepath.Join(dir, "repo", "mychart-1.0.0.tgz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := "sha256:" + archiveDigest(archive)
+	tarballURL := server.URL + "/mychart-1.0.0.tgz"
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "charts"},
+		BinaryData: map[string][]byte{"mychart.tgz": archive},
+	}
+
+	tests := []struct {
+		name    string
+		source  v1.ChartSource
+		wantURL string
+		wantErr bool
+	}{
+		{
+			name:    "tarball",
+			source:  v1.ChartSource{Tarball: &v1.TarballSource{URL: tarballURL}},
+			wantURL: tarballURL,
+		},
+		{
+			name:    "pinned tarball",
+			source:  v1.ChartSource{Tarball: &v1.TarballSource{URL: tarballURL, Digest: digest}},
+			wantURL: tarballURL,
+		},
+		{
+			name:    "tarball with wrong digest",
+			source:  v1.ChartSource{Tarball: &v1.TarballSource{URL: tarballURL, Digest: "sha256:0123"}},
+			wantErr: true,
+		},
+		{
+			name:    "configmap",
+			source:  v1.ChartSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "charts"}, Key: "mychart.tgz"}},
+			wantURL: "configmap:default/charts/mychart.tgz",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease("")
+			source := tt.source
+			hr.Spec.Source = &source
+			c, clientset := newTestController(t, NewFakeBackend(), hr, cm)
+
+			err = c.updateRelease(testNamespace + "/" + testName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateRelease() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &v1.SourceStatus{URL: tt.wantURL, Revision: digest}
+			if !reflect.DeepEqual(got.Status.Source, want) {
+				t.Errorf("source = %+v, want %+v", got.Status.Source, want)
+			}
+		})
+	}
+}
+
+func TestUpdateReleaseDependencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	dbReady := &v1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "mydb", Generation: 2},
+		Status:     v1.HelmReleaseStatus{Phase: v1.HelmRealeasePhaseReady, ObservedGeneration: 2},
+	}
+	dbUpgrading := dbReady.DeepCopy()
+	dbUpgrading.Generation = 3
+	dbCycle := dbReady.DeepCopy()
+	dbCycle.Spec.DependsOn = []v1.ReleaseReference{{Namespace: testNamespace, Name: testName}}
+
+	tests := []struct {
+		name        string
+		db          *v1.HelmRelease
+		wantPhase   v1.HelmRealeasePhase
+		wantInstall bool
+	}{
+		{name: "missing dependency", wantPhase: v1.HelmRealeasePhaseWaiting},
+		{name: "dependency not ready", db: dbUpgrading, wantPhase: v1.HelmRealeasePhaseWaiting},
+		{name: "dependency ready", db: dbReady, wantPhase: v1.HelmRealeasePhaseReady, wantInstall: true},
+		{name: "cycle", db: dbCycle, wantPhase: v1.HelmRealeasePhaseFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease(server.URL)
+			hr.Spec.DependsOn = []v1.ReleaseReference{{Namespace: "db", Name: "mydb"}}
+			backend := NewFakeBackend()
+			objs := []runtime.Object{hr}
+			if tt.db != nil {
+				objs = append(objs, tt.db)
+			}
+			c, clientset := newTestController(t, backend, objs...)
+
+			if err := c.updateRelease(testNamespace + "/" + testName); err != nil {
+				t.Fatal(err)
+			}
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %q, want %q", got.Status.Phase, tt.wantPhase)
+			}
+			if installed := len(backend.Releases) == 1; installed != tt.wantInstall {
+				t.Errorf("installed = %v, want %v", installed, tt.wantInstall)
+			}
+		})
+	}
+}
+
+// fakeResources is an in-memory liveResources
+type fakeResources struct {
+	objs    map[string]*unstructured.Unstructured
+	applied []string
+}
+
+func (r *fakeResources) Get(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	live, ok := r.objs[resourceKey(obj)]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: obj.GetKind()}, obj.GetName())
+	}
+	return live, nil
+}
+
+func (r *fakeResources) Apply(obj *unstructured.Unstructured) error {
+	r.applied = append(r.applied, obj.GetName())
+	r.objs[resourceKey(obj)] = obj
+	return nil
+}
+
+func TestCheckDrift(t *testing.T) {
+	const manifest = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: value
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  labels:
+    app: app
+spec:
+  replicas: 2
+`
+	objs, err := parseManifest(manifest, testNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// live returns the live resources of the manifest, as modified by
+	// modify
+	live := func(modify func(m map[string]*unstructured.Unstructured, app *unstructured.Unstructured)) map[string]*unstructured.Unstructured {
+		app := objs[1].DeepCopy()
+		// Defaults and metadata set by the API server aren't drift
+		app.SetUID("1234")
+		unstructured.SetNestedField(app.Object, "RollingUpdate", "spec", "strategy", "type")
+		unstructured.SetNestedField(app.Object, int64(1), "status", "replicas")
+		m := map[string]*unstructured.Unstructured{
+			resourceKey(objs[0]): objs[0].DeepCopy(),
+			resourceKey(app):     app,
+		}
+		if modify != nil {
+			modify(m, app)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name        string
+		policy      v1.DriftPolicy
+		live        map[string]*unstructured.Unstructured
+		wantDrifted []v1.DriftedResource
+		wantApplied []string
+	}{
+		{
+			name: "no drift",
+			live: live(nil),
+		},
+		{
+			name: "modified",
+			live: live(func(m map[string]*unstructured.Unstructured, app *unstructured.Unstructured) {
+				unstructured.SetNestedField(app.Object, int64(5), "spec", "replicas")
+				app.SetLabels(nil)
+			}),
+			wantDrifted: []v1.DriftedResource{{
+				APIVersion: "apps/v1", Kind: "Deployment", Namespace: testNamespace, Name: "app",
+				Reason: "Modified", Fields: []string{".metadata.labels.app", ".spec.replicas"},
+			}},
+		},
+		{
+			name:   "missing and corrected",
+			policy: v1.DriftPolicyCorrect,
+			live: live(func(m map[string]*unstructured.Unstructured, app *unstructured.Unstructured) {
+				delete(m, resourceKey(objs[0]))
+			}),
+			wantDrifted: []v1.DriftedResource{{
+				APIVersion: "v1", Kind: "ConfigMap", Namespace: testNamespace, Name: "config", Reason: "Missing",
+			}},
+			wantApplied: []string{"config"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease("")
+			hr.Spec.DriftPolicy = tt.policy
+			hr.Status.Phase = v1.HelmRealeasePhaseReady
+			backend := NewFakeBackend()
+			backend.Releases[testRelease] = deployedRelease()
+			backend.Releases[testRelease][0].Manifest = manifest
+			c, clientset := newTestController(t, backend, hr)
+			resources := &fakeResources{objs: tt.live}
+			c.resources = resources
+
+			if err := c.checkDrift(testNamespace+"/"+testName, hr); err != nil {
+				t.Fatal(err)
+			}
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Drift == nil || !reflect.DeepEqual(got.Status.Drift.Resources, tt.wantDrifted) {
+				t.Errorf("drift = %+v, want resources %+v", got.Status.Drift, tt.wantDrifted)
+			}
+			if !reflect.DeepEqual(resources.applied, tt.wantApplied) {
+				t.Errorf("applied %v, want %v", resources.applied, tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestSyncHealth(t *testing.T) {
+	const manifest = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 2
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+`
+	replicas := int32(2)
+	deployment := func(available int32) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "app", Generation: 1},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{
+				ObservedGeneration: 1,
+				Replicas:           2,
+				UpdatedReplicas:    2,
+				AvailableReplicas:  available,
+			},
+		}
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "data"},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+
+	tests := []struct {
+		name          string
+		deployment    *appsv1.Deployment
+		wantHealthy   corev1.ConditionStatus
+		wantResources []v1.ResourceStatus
+	}{
+		{
+			name:        "ready",
+			deployment:  deployment(2),
+			wantHealthy: corev1.ConditionTrue,
+			wantResources: []v1.ResourceStatus{
+				{Kind: "PersistentVolumeClaim", Namespace: testNamespace, Name: "data", Ready: true, Message: "Bound"},
+				{Kind: "Deployment", Namespace: testNamespace, Name: "app", Ready: true, Message: "2/2 replicas available"},
+			},
+		},
+		{
+			name:        "unavailable",
+			deployment:  deployment(1),
+			wantHealthy: corev1.ConditionFalse,
+			wantResources: []v1.ResourceStatus{
+				{Kind: "PersistentVolumeClaim", Namespace: testNamespace, Name: "data", Ready: true, Message: "Bound"},
+				{Kind: "Deployment", Namespace: testNamespace, Name: "app", Ready: false, Message: "1/2 replicas available"},
+			},
+		},
+		{
+			name:        "missing",
+			wantHealthy: corev1.ConditionFalse,
+			wantResources: []v1.ResourceStatus{
+				{Kind: "PersistentVolumeClaim", Namespace: testNamespace, Name: "data", Ready: true, Message: "Bound"},
+				{Kind: "Deployment", Namespace: testNamespace, Name: "app", Ready: false, Message: "not found"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease("")
+			hr.Status.Phase = v1.HelmRealeasePhaseReady
+			hr.Status.Revision = 1
+			backend := NewFakeBackend()
+			backend.Releases[testRelease] = deployedRelease()
+			backend.Releases[testRelease][0].Manifest = manifest
+			objs := []runtime.Object{hr, pvc}
+			if tt.deployment != nil {
+				objs = append(objs, tt.deployment)
+			}
+			c, clientset := newTestController(t, backend, objs...)
+
+			key := testNamespace + "/" + testName
+			if err := c.syncHealth(key); err != nil {
+				t.Fatal(err)
+			}
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if healthy := conditionStatus(&got.Status, v1.HelmReleaseConditionHealthy); healthy != tt.wantHealthy {
+				t.Errorf("Healthy = %s, want %s", healthy, tt.wantHealthy)
+			}
+			if !reflect.DeepEqual(got.Status.Resources, tt.wantResources) {
+				t.Errorf("resources = %+v, want %+v", got.Status.Resources, tt.wantResources)
+			}
+			if owners := c.health.ownersOf(kindDeployment, testNamespace, "app"); !reflect.DeepEqual(owners, []string{key}) {
+				t.Errorf("owners of the deployment = %v, want %v", owners, []string{key})
+			}
+		})
+	}
+}
+
+func TestUpdateReleaseWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		atomic     bool
+		installErr error
+		wantErr    bool
+		// wantDone are the calls made once the operation is done
+		wantDone    []string
+		wantMethods []string
+	}{
+		{
+			name:        "wait",
+			wantDone:    []string{"History", "Install"},
+			wantMethods: []string{"History", "Install", "Status"},
+		},
+		{
+			name:        "atomic",
+			atomic:      true,
+			installErr:  errors.New("timed out waiting for the condition"),
+			wantErr:     true,
+			wantDone:    []string{"History", "Install", "Delete"},
+			wantMethods: []string{"History", "Install", "Delete"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease(server.URL)
+			hr.Spec.Wait = !tt.atomic
+			hr.Spec.Atomic = tt.atomic
+			hr.Spec.Timeout = 60
+			backend := NewFakeBackend()
+			if tt.installErr != nil {
+				backend.Errors["Install"] = tt.installErr
+			}
+			c, clientset := newTestController(t, backend, hr)
+
+			key := testNamespace + "/" + testName
+			if err := c.updateRelease(key); err != nil {
+				t.Fatal(err)
+			}
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Phase != v1.HelmRealeasePhaseDeploying {
+				t.Errorf("phase while waiting = %q, want %q", got.Status.Phase, v1.HelmRealeasePhaseDeploying)
+			}
+			<-c.pendingOperation(key).done
+			if methods := backend.Methods(); !reflect.DeepEqual(methods, tt.wantDone) {
+				t.Errorf("calls once done = %v, want %v", methods, tt.wantDone)
+			}
+			c.informer.GetIndexer().Update(got)
+
+			err = c.updateRelease(key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateRelease() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if methods := backend.Methods(); !reflect.DeepEqual(methods, tt.wantMethods) {
+				t.Errorf("calls = %v, want %v", methods, tt.wantMethods)
+			}
+			want := InstallOptions{Name: testRelease, Namespace: testNamespace, Values: []byte("replicas: 2\n"), Wait: true, Timeout: 60}
+			if opts := backend.Calls[1].Options; !reflect.DeepEqual(opts, want) {
+				t.Errorf("install options = %+v, want %+v", opts, want)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err = clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Phase != v1.HelmRealeasePhaseReady || got.Status.Revision != 1 {
+				t.Errorf("phase = %q, revision %d, want Ready, revision 1", got.Status.Phase, got.Status.Revision)
+			}
+		})
+	}
+}
+
+func TestBackgroundOperationRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	hr := newTestRelease(server.URL)
+	hr.Spec.Atomic = true
+	backend := NewFakeBackend()
+	backend.Errors["Install"] = errors.New("timed out waiting for the condition")
+	c, clientset := newTestController(t, backend, hr)
+
+	// Each attempt is started then recorded once done
+	key := testNamespace + "/" + testName
+	c.queue.Add(key)
+	for i := 0; i < 2*(maxRetries+1); i++ {
+		c.processNextItem()
+		if op := c.pendingOperation(key); op != nil {
+			<-op.done
+		}
+	}
+
+	installs := 0
+	for _, m := range backend.Methods() {
+		if m == "Install" {
+			installs++
+		}
+	}
+	if installs != maxRetries+1 {
+		t.Errorf("%d installs, want %d", installs, maxRetries+1)
+	}
+	if n := c.queue.NumRequeues(key); n != 0 {
+		t.Errorf("%d requeues after giving up, want 0", n)
+	}
+	got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Phase != v1.HelmRealeasePhaseFailed || got.Status.FailMsg == "" {
+		t.Errorf("phase = %q, failMsg %q, want Failed with a message", got.Status.Phase, got.Status.FailMsg)
+	}
+}
+
+func TestUpdateReleaseAtomicRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	hr := newTestRelease(server.URL)
+	hr.Spec.Atomic = true
+	hr.Status.LastGoodRevision = 1
+	backend := NewFakeBackend()
+	backend.Releases[testRelease] = deployedRelease()
+	backend.Errors["Upgrade"] = errors.New("timed out waiting for the condition")
+	c, clientset := newTestController(t, backend, hr)
+
+	key := testNamespace + "/" + testName
+	if err := c.updateRelease(key); err != nil {
+		t.Fatal(err)
+	}
+	// The rollback waits in the background too
+	<-c.pendingOperation(key).done
+	want := []string{"History", "Upgrade", "Rollback"}
+	if methods := backend.Methods(); !reflect.DeepEqual(methods, want) {
+		t.Errorf("calls once done = %v, want %v", methods, want)
+	}
+	wantOpts := RollbackOptions{Revision: 1, Timeout: defaultRollbackTimeout, Wait: true}
+	if opts := backend.Calls[2].Options; !reflect.DeepEqual(opts, wantOpts) {
+		t.Errorf("rollback options = %+v, want %+v", opts, wantOpts)
+	}
+
+	if err := c.updateRelease(key); err != nil {
+		t.Fatal(err)
+	}
+	if methods := backend.Methods(); !reflect.DeepEqual(methods, want) {
+		t.Errorf("calls = %v, want %v", methods, want)
+	}
+	got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Phase != v1.HelmRealeasePhaseRolledBack || got.Status.Revision != 2 {
+		t.Errorf("phase = %q, revision %d, want RolledBack, revision 2", got.Status.Phase, got.Status.Revision)
+	}
+}
+
+func TestUpdateReleaseTests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		status      release.TestRun_Status
+		wantTested  corev1.ConditionStatus
+		wantPhase   v1.HelmRealeasePhase
+		wantMethods []string
+	}{
+		{
+			name:        "passed",
+			status:      release.TestRun_SUCCESS,
+			wantTested:  corev1.ConditionTrue,
+			wantPhase:   v1.HelmRealeasePhaseReady,
+			wantMethods: []string{"History", "Upgrade", "Test", "Status"},
+		},
+		{
+			name:        "failed",
+			status:      release.TestRun_FAILURE,
+			wantTested:  corev1.ConditionFalse,
+			wantPhase:   v1.HelmRealeasePhaseRolledBack,
+			wantMethods: []string{"History", "Upgrade", "Test", "Rollback"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease(server.URL)
+			hr.Spec.DisableHooks = true
+			hr.Spec.Test = &v1.TestSpec{Enable: true, Cleanup: true, RollbackOnFailure: true}
+			hr.Status.LastGoodRevision = 1
+			backend := NewFakeBackend()
+			backend.Releases[testRelease] = deployedRelease()
+			backend.TestResults = []*release.TestRun{{Name: "mychart-test", Status: tt.status, Info: "done"}}
+			c, clientset := newTestController(t, backend, hr)
+			// Results are recorded while the tests run
+			var streamed bool
+			clientset.PrependReactor("update", "helmreleases", func(action ktesting.Action) (bool, runtime.Object, error) {
+				tests := action.(ktesting.UpdateAction).GetObject().(*v1.HelmRelease).Status.Tests
+				if tests != nil && len(tests.Results) == 1 && tests.Results[0].Phase == testPhase(release.TestRun_RUNNING) {
+					streamed = true
+				}
+				return false, nil, nil
+			})
+
+			key := testNamespace + "/" + testName
+			if err := c.updateRelease(key); err != nil {
+				t.Fatal(err)
+			}
+			<-c.pendingOperation(key).done
+			if !streamed {
+				t.Error("running tests not recorded in the status")
+			}
+			if err := c.updateRelease(key); err != nil {
+				t.Fatal(err)
+			}
+
+			if methods := backend.Methods(); !reflect.DeepEqual(methods, tt.wantMethods) {
+				t.Errorf("calls = %v, want %v", methods, tt.wantMethods)
+			}
+			if opts := backend.Calls[1].Options.(UpgradeOptions); !opts.DisableHooks {
+				t.Errorf("upgrade options = %+v, want hooks disabled", opts)
+			}
+			if opts := backend.Calls[2].Options.(TestOptions); !opts.Cleanup || opts.Timeout != defaultTimeout {
+				t.Errorf("test options = %+v, want cleanup and the default timeout", opts)
+			}
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %q, want %q", got.Status.Phase, tt.wantPhase)
+			}
+			if tested := conditionStatus(&got.Status, v1.HelmReleaseConditionTested); tested != tt.wantTested {
+				t.Errorf("Tested = %s, want %s", tested, tt.wantTested)
+			}
+			want := []v1.TestResult{{Name: "mychart-test", Phase: testPhase(tt.status), Message: "done"}}
+			if got.Status.Tests == nil || got.Status.Tests.Revision != 2 || !reflect.DeepEqual(got.Status.Tests.Results, want) {
+				t.Errorf("tests = %+v, want revision 2, results %+v", got.Status.Tests, want)
+			}
+		})
+	}
+}
+
+func TestPausedEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := newTestRepo(t, dir)
+	defer server.Close()
+
+	running := newTestRelease(server.URL)
+	running.ResourceVersion = "1"
+	running.Finalizers = []string{v1.ReleaseFinalizer}
+	paused := running.DeepCopy()
+	paused.ResourceVersion = "2"
+	paused.Generation = 2
+	paused.Spec.Paused = true
+	resynced := paused.DeepCopy()
+	resynced.ResourceVersion = "3"
+
+	backend := NewFakeBackend()
+	c, _ := newTestController(t, backend, paused)
+	recorder := record.NewFakeRecorder(100)
+	c.recorder = recorder
+
+	c.onUpdateFunc(running, paused)
+	c.onUpdateFunc(paused, resynced)
+	for i := 0; i < 2; i++ {
+		if err := c.updateRelease(testNamespace + "/" + testName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(backend.Calls) > 0 {
+		t.Errorf("paused release was deployed, calls: %v", backend.Methods())
+	}
+	close(recorder.Events)
+	var events []string
+	for e := range recorder.Events {
+		events = append(events, e)
+	}
+	if want := []string{"Normal Paused HelmRelease is paused"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
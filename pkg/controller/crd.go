@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"strings"
+
 	"github.com/golang/glog"
 	apiextensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	extclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
@@ -10,32 +12,66 @@ import (
 	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
 )
 
-func ensureCustomResource(extClientset extclientset.Interface) error {
-	crd := &apiextensions.CustomResourceDefinition{
+func customResourceDefinition(plural, kind string, scope apiextensions.ResourceScope, shortNames ...string) *apiextensions.CustomResourceDefinition {
+	return &apiextensions.CustomResourceDefinition{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "helmreleases." + v1.SchemeGroupVersion.Group,
+			Name: plural + "." + v1.SchemeGroupVersion.Group,
 		},
 		Spec: apiextensions.CustomResourceDefinitionSpec{
 			Group:   v1.SchemeGroupVersion.Group,
 			Version: v1.SchemeGroupVersion.Version,
-			Scope:   apiextensions.NamespaceScoped,
+			Scope:   scope,
 			Names: apiextensions.CustomResourceDefinitionNames{
-				Plural:     "helmreleases",
-				Singular:   "helmrelease",
-				Kind:       "HelmRelease",
-				ListKind:   "HelmReleaseList",
-				ShortNames: []string{"hrl"},
+				Plural:     plural,
+				Singular:   strings.ToLower(kind),
+				Kind:       kind,
+				ListKind:   kind + "List",
+				ShortNames: shortNames,
+			},
+			Subresources: &apiextensions.CustomResourceSubresources{
+				Status: &apiextensions.CustomResourceSubresourceStatus{},
 			},
 		},
 	}
-	_, err := extClientset.ApiextensionsV1beta1().CustomResourceDefinitions().Create(crd)
+}
+
+func ensureCustomResources(extClientset extclientset.Interface) error {
+	for _, crd := range []*apiextensions.CustomResourceDefinition{
+		customResourceDefinition("helmreleases", "HelmRelease", apiextensions.NamespaceScoped, "hrl"),
+		customResourceDefinition("helmrepositories", "HelmRepository", apiextensions.NamespaceScoped, "hrepo"),
+		customResourceDefinition("clusterhelmrepositories", "ClusterHelmRepository", apiextensions.ClusterScoped, "chrepo"),
+	} {
+		if err := ensureCustomResource(extClientset, crd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ensureCustomResource(extClientset extclientset.Interface, crd *apiextensions.CustomResourceDefinition) error {
+	kind := crd.Spec.Names.Kind
+	crdClient := extClientset.ApiextensionsV1beta1().CustomResourceDefinitions()
+	_, err := crdClient.Create(crd)
 	if apierrors.IsAlreadyExists(err) {
-		glog.Info("Skip the creation for CustomResourceDefinition HelmReleases because it has already been created")
+		existing, err := crdClient.Get(crd.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if existing.Spec.Subresources != nil && existing.Spec.Subresources.Status != nil {
+			glog.Infof("Skip the creation for CustomResourceDefinition %s because it has already been created", kind)
+			return nil
+		}
+		// Created by an older controller, enable the status subresource
+		existing.Spec.Subresources = crd.Spec.Subresources
+		if _, err := crdClient.Update(existing); err != nil {
+			return err
+		}
+		glog.Infof("Enabled status subresource on CustomResourceDefinition %s", kind)
 		return nil
 	}
 	if err != nil {
 		return err
 	}
-	glog.Info("Create CustomResourceDefinition HelmReleases successfully")
+	glog.Infof("Create CustomResourceDefinition %s successfully", kind)
 	return nil
 }
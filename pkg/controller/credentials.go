@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/tlsutil"
+	"k8s.io/helm/pkg/urlutil"
+	"k8s.io/helm/pkg/version"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// httpTimeout bounds each request to repositories and registries, so
+// that unresponsive servers don't block a worker
+const httpTimeout = 2 * time.Minute
+
+// defaultHTTPClient is the client of repositories and registries without
+// TLS material
+var defaultHTTPClient = &http.Client{Timeout: httpTimeout}
+
+// repoCredentials holds what is needed to access a chart repository.
+// TLS material is materialized as files under dir, which is removed by
+// cleanup once the reconcile is done.
+type repoCredentials struct {
+	username string
+	password string
+	certFile string
+	keyFile  string
+	caFile   string
+	dir      string
+}
+
+// repoCredentials returns the credentials of the repository used by hr,
+// read from spec.repoCredentialsSecretRef or, failing that, from the
+// deprecated spec.username/spec.password.
+func (c *Controller) repoCredentials(hr *v1.HelmRelease) (*repoCredentials, error) {
+	ref := hr.Spec.RepoCredentialsSecretRef
+	if ref == nil {
+		return &repoCredentials{
+			username: hr.Spec.Username,
+			password: hr.Spec.Password,
+		}, nil
+	}
+	return c.secretCredentials(hr.Namespace, ref.Name)
+}
+
+// secretCredentials returns the repository credentials held by the
+// Secret ns/name
+func (c *Controller) secretCredentials(ns, name string) (*repoCredentials, error) {
+	obj, exists, err := c.secretInformer.GetIndexer().GetByKey(ns + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("repository credentials secret %s/%s not found", ns, name)
+	}
+	secret := obj.(*corev1.Secret)
+
+	creds := &repoCredentials{
+		username: string(secret.Data[corev1.BasicAuthUsernameKey]),
+		password: string(secret.Data[corev1.BasicAuthPasswordKey]),
+	}
+
+	files := []struct {
+		key  string
+		dest *string
+	}{
+		{corev1.TLSCertKey, &creds.certFile},
+		{corev1.TLSPrivateKeyKey, &creds.keyFile},
+		{corev1.ServiceAccountRootCAKey, &creds.caFile},
+	}
+	for _, f := range files {
+		data, ok := secret.Data[f.key]
+		if !ok {
+			continue
+		}
+		if err := creds.writeFile(f.key, data, f.dest); err != nil {
+			creds.cleanup()
+			return nil, err
+		}
+	}
+	if (creds.certFile == "") != (creds.keyFile == "") {
+		creds.cleanup()
+		return nil, fmt.Errorf("repository credentials secret %s/%s must contain both %q and %q",
+			ns, name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+	return creds, nil
+}
+
+// writeFile materializes data as the file name of the credentials, and
+// sets dest to its path
+func (r *repoCredentials) writeFile(name string, data []byte, dest *string) error {
+	if r.dir == "" {
+		dir, err := ioutil.TempDir("", "helm-crd-tls-")
+		if err != nil {
+			return err
+		}
+		r.dir = dir
+	}
+	*dest = filepath.Join(r.dir, name)
+	return ioutil.WriteFile(*dest, data, 0600)
+}
+
+// cleanup removes the TLS files materialized for these credentials
+func (r *repoCredentials) cleanup() {
+	if r.dir == "" {
+		return
+	}
+	if err := os.RemoveAll(r.dir); err != nil {
+		glog.Warningf("Unable to remove %s: %v", r.dir, err)
+	}
+}
+
+// cacheKey returns a hash of all of these credentials, TLS material
+// included, so that what is cached for some credentials isn't served to
+// others. Credentials without any secret hash to the empty string.
+func (r *repoCredentials) cacheKey() string {
+	if *r == (repoCredentials{}) {
+		return ""
+	}
+	h := sha256.New()
+	for _, s := range []string{r.username, r.password} {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	for _, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		var data []byte
+		if path != "" {
+			var err error
+			if data, err = ioutil.ReadFile(path); err != nil {
+				// Unreadable material can't match anything cached
+				data = []byte(path)
+			}
+		}
+		fmt.Fprintf(h, "%d:%s", len(data), data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// httpClient returns a client for u using the TLS material of these
+// credentials. Unlike the Helm HTTP getter, the CA certificate is used
+// even without a client certificate.
+func (r *repoCredentials) httpClient(u string) (*http.Client, error) {
+	if r.certFile == "" && r.caFile == "" {
+		return defaultHTTPClient, nil
+	}
+	tlsConf := &tls.Config{}
+	if r.certFile != "" {
+		cert, err := tlsutil.CertFromFilePair(r.certFile, r.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't create TLS config for client: %v", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{*cert}
+	}
+	if r.caFile != "" {
+		pool, err := tlsutil.CertPoolFromFile(r.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't create TLS config for client: %v", err)
+		}
+		tlsConf.RootCAs = pool
+	}
+	sni, err := urlutil.ExtractHostname(u)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf.ServerName = sni
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConf,
+			Proxy:           http.ProxyFromEnvironment,
+		},
+		Timeout: httpTimeout,
+	}, nil
+}
+
+// newRequest returns a GET request for u, authenticated with these
+// credentials if u is served from the same scheme and host as the
+// repository at repoURL. Indexes may point at charts hosted elsewhere,
+// such as on CDNs, which must not get the credentials.
+func (r *repoCredentials) newRequest(u, repoURL string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Identify as Helm, as some repositories serve it differently
+	req.Header.Set("User-Agent", "Helm/"+strings.TrimPrefix(version.GetVersion(), "v"))
+	if r.username != "" && r.password != "" && sameOrigin(req.URL, repoURL) {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	return req, nil
+}
+
+// sameOrigin returns whether u has the scheme and host of repoURL
+func sameOrigin(u *url.URL, repoURL string) bool {
+	repo, err := url.Parse(repoURL)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == repo.Scheme && strings.EqualFold(u.Host, repo.Host)
+}
+
+// httpGetter is a getter.Getter using the credentials of the repository
+// at repoURL
+type httpGetter struct {
+	creds   *repoCredentials
+	repoURL string
+	client  *http.Client
+}
+
+func (g *httpGetter) Get(u string) (*bytes.Buffer, error) {
+	req, err := g.creds.newRequest(u, g.repoURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %s : %s", u, resp.Status)
+	}
+	buf := bytes.NewBuffer(nil)
+	_, err = io.Copy(buf, resp.Body)
+	return buf, err
+}
+
+// getters returns providers whose HTTP(S) getter uses these credentials
+// for the repository at repoURL, falling back to providers for other
+// schemes. The chart downloader does not pass credentials along for
+// absolute chart URLs, so they are set here instead.
+func (r *repoCredentials) getters(repoURL string, providers getter.Providers) getter.Providers {
+	return append(getter.Providers{{
+		Schemes: []string{"http", "https"},
+		New: func(URL, _, _, _ string) (getter.Getter, error) {
+			client, err := r.httpClient(URL)
+			if err != nil {
+				return nil, err
+			}
+			return &httpGetter{creds: r, repoURL: repoURL, client: client}, nil
+		},
+	}}, providers...)
+}
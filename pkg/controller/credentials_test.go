@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRepoCredentials(t *testing.T) {
+	dir := t.TempDir()
+	// TLS files are written under TMPDIR
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", dir)
+	leftovers := func() int {
+		dirs, _ := filepath.Glob(filepath.Join(dir, "helm-crd-tls-*"))
+		return len(dirs)
+	}
+
+	secret := func(name string, data map[string]string) *corev1.Secret {
+		s := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name},
+			Data:       map[string][]byte{},
+		}
+		for k, v := range data {
+			s.Data[k] = []byte(v)
+		}
+		return s
+	}
+	secrets := []*corev1.Secret{
+		secret("basic", map[string]string{"username": "user", "password": "pass"}),
+		secret("tls", map[string]string{"tls.crt": "cert", "tls.key": "key", "ca.crt": "ca"}),
+		secret("ca", map[string]string{"ca.crt": "ca"}),
+		secret("cert-only", map[string]string{"tls.crt": "cert", "ca.crt": "ca"}),
+	}
+
+	tests := []struct {
+		name string
+		// secret is the name of the referenced Secret, if any
+		secret       string
+		username     string
+		password     string
+		wantUsername string
+		wantPassword string
+		wantFiles    map[string]string
+		wantErr      bool
+	}{
+		{
+			name:         "inline credentials",
+			username:     "inline",
+			password:     "secret",
+			wantUsername: "inline",
+			wantPassword: "secret",
+		},
+		{
+			name:         "basic auth secret takes precedence",
+			secret:       "basic",
+			username:     "inline",
+			password:     "secret",
+			wantUsername: "user",
+			wantPassword: "pass",
+		},
+		{
+			name:      "client certificate",
+			secret:    "tls",
+			wantFiles: map[string]string{"tls.crt": "cert", "tls.key": "key", "ca.crt": "ca"},
+		},
+		{
+			name:      "CA certificate only",
+			secret:    "ca",
+			wantFiles: map[string]string{"ca.crt": "ca"},
+		},
+		{
+			name:    "certificate without key",
+			secret:  "cert-only",
+			wantErr: true,
+		},
+		{
+			name:    "missing secret",
+			secret:  "missing",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease("")
+			hr.Spec.Username = tt.username
+			hr.Spec.Password = tt.password
+			if tt.secret != "" {
+				hr.Spec.RepoCredentialsSecretRef = &corev1.LocalObjectReference{Name: tt.secret}
+			}
+			c, _ := newTestController(t, NewFakeBackend(), hr)
+			for _, s := range secrets {
+				c.secretInformer.GetIndexer().Add(s)
+			}
+
+			creds, err := c.repoCredentials(hr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("repoCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// Files written before the error are removed
+				if n := leftovers(); n > 0 {
+					t.Errorf("%d temporary directories left behind", n)
+				}
+				return
+			}
+			if creds.username != tt.wantUsername || creds.password != tt.wantPassword {
+				t.Errorf("credentials = %q/%q, want %q/%q", creds.username, creds.password, tt.wantUsername, tt.wantPassword)
+			}
+			paths := map[string]string{"tls.crt": creds.certFile, "tls.key": creds.keyFile, "ca.crt": creds.caFile}
+			for key, path := range paths {
+				want, ok := tt.wantFiles[key]
+				if !ok {
+					if path != "" {
+						t.Errorf("%s written to %s, want none", key, path)
+					}
+					continue
+				}
+				got, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Errorf("reading %s: %v", key, err)
+				} else if string(got) != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+
+			creds.cleanup()
+			if n := leftovers(); n > 0 {
+				t.Errorf("%d temporary directories left after cleanup", n)
+			}
+		})
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	const repoURL = "https://charts.example.com/stable"
+	creds := &repoCredentials{username: "user", password: "pass"}
+	tests := []struct {
+		url      string
+		wantAuth bool
+	}{
+		{url: "https://charts.example.com/stable/index.yaml", wantAuth: true},
+		{url: "https://CHARTS.example.com/other/mychart-1.0.0.tgz", wantAuth: true},
+		{url: "https://cdn.example.com/mychart-1.0.0.tgz"},
+		{url: "https://charts.example.com:8443/stable/mychart-1.0.0.tgz"},
+		{url: "http://charts.example.com/stable/mychart-1.0.0.tgz"},
+	}
+	for _, tt := range tests {
+		req, err := creds.newRequest(tt.url, repoURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, ok := req.BasicAuth(); ok != tt.wantAuth {
+			t.Errorf("newRequest(%q) authenticated = %v, want %v", tt.url, ok, tt.wantAuth)
+		}
+	}
+}
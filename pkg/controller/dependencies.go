@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// dependsOnIndex indexes HelmReleases by the keys of the HelmReleases
+// they list in spec.dependsOn
+const dependsOnIndex = "dependsOn"
+
+// dependencyKeys returns the keys of the HelmReleases hr depends on
+func dependencyKeys(hr *v1.HelmRelease) []string {
+	keys := make([]string, 0, len(hr.Spec.DependsOn))
+	for _, dep := range hr.Spec.DependsOn {
+		ns := dep.Namespace
+		if ns == "" {
+			ns = hr.Namespace
+		}
+		keys = append(keys, ns+"/"+dep.Name)
+	}
+	return keys
+}
+
+func dependsOnIndexFunc(obj interface{}) ([]string, error) {
+	hr, ok := obj.(*v1.HelmRelease)
+	if !ok {
+		return nil, nil
+	}
+	return dependencyKeys(hr), nil
+}
+
+// releaseReady returns whether hr is installed at its current generation
+func releaseReady(hr *v1.HelmRelease) bool {
+	return hr.Status.Phase == v1.HelmRealeasePhaseReady &&
+		hr.Status.ObservedGeneration == hr.Generation &&
+		hr.DeletionTimestamp == nil
+}
+
+// dependencyCycle returns the cycle of dependencies going through key, if
+// any, as a list of keys starting and ending with key
+func (c *Controller) dependencyCycle(key string, hr *v1.HelmRelease) []string {
+	visited := map[string]bool{}
+	var visit func(hr *v1.HelmRelease, path []string) []string
+	visit = func(hr *v1.HelmRelease, path []string) []string {
+		for _, dep := range dependencyKeys(hr) {
+			if dep == key {
+				return append(path, dep)
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			obj, exists, err := c.informer.GetIndexer().GetByKey(dep)
+			if err != nil || !exists {
+				continue
+			}
+			if cycle := visit(obj.(*v1.HelmRelease), append(path, dep)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return visit(hr, []string{key})
+}
+
+// waitForDependencies checks the HelmReleases hr depends on. Unless they
+// are all ready, hr is moved to the Waiting phase, or to the Failed phase
+// if they depend on it, and true is returned. HelmReleases waiting for
+// a dependency are requeued once it becomes ready, see
+// enqueueDependents.
+func (c *Controller) waitForDependencies(key string, hr *v1.HelmRelease) (bool, error) {
+	if cycle := c.dependencyCycle(key, hr); cycle != nil {
+		msg := fmt.Sprintf("Dependency cycle: %s", strings.Join(cycle, " -> "))
+		hr.Status.Phase = v1.HelmRealeasePhaseFailed
+		hr.Status.FailMsg = msg
+		setCondition(&hr.Status, v1.HelmReleaseConditionDependenciesReady, corev1.ConditionFalse, reasonDependencyCycle, msg)
+		setCondition(&hr.Status, v1.HelmReleaseConditionReady, corev1.ConditionFalse, reasonDependencyCycle, msg)
+		c.warningEventf(key, hr, reasonDependencyCycle, "%s", msg)
+		_, err := c.updateStatus(hr)
+		return true, err
+	}
+
+	var waiting []string
+	for _, dep := range dependencyKeys(hr) {
+		obj, exists, err := c.informer.GetIndexer().GetByKey(dep)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			waiting = append(waiting, dep+" (not found)")
+		} else if !releaseReady(obj.(*v1.HelmRelease)) {
+			waiting = append(waiting, dep)
+		}
+	}
+	if len(waiting) == 0 {
+		setCondition(&hr.Status, v1.HelmReleaseConditionDependenciesReady, corev1.ConditionTrue, reasonDependencyReady, "")
+		return false, nil
+	}
+
+	msg := fmt.Sprintf("Waiting for %s", strings.Join(waiting, ", "))
+	glog.Infof("HelmRelease %s: %s", key, msg)
+	if hr.Status.Phase != v1.HelmRealeasePhaseWaiting {
+		c.recorder.Event(hr, corev1.EventTypeNormal, reasonWaiting, msg)
+	}
+	hr.Status.Phase = v1.HelmRealeasePhaseWaiting
+	hr.Status.FailMsg = ""
+	setCondition(&hr.Status, v1.HelmReleaseConditionDependenciesReady, corev1.ConditionFalse, reasonWaiting, msg)
+	setCondition(&hr.Status, v1.HelmReleaseConditionReady, corev1.ConditionFalse, reasonWaiting, msg)
+	_, err := c.updateStatus(hr)
+	return true, err
+}
+
+// enqueueDependents requeues the HelmReleases depending on hr
+func (c *Controller) enqueueDependents(hr *v1.HelmRelease) {
+	key, err := cache.MetaNamespaceKeyFunc(hr)
+	if err != nil {
+		return
+	}
+	dependents, err := c.informer.GetIndexer().ByIndex(dependsOnIndex, key)
+	if err != nil {
+		glog.Errorf("Error looking up HelmReleases depending on %s: %v", key, err)
+		return
+	}
+	for _, obj := range dependents {
+		if dependent, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			glog.Infof("HelmRelease %s is ready, requeueing HelmRelease %s", key, dependent)
+			c.queue.Add(dependent)
+		}
+	}
+}
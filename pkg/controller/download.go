@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/repo"
+)
+
+// chartGetter returns a getter for u using the providers of its scheme
+func chartGetter(u string, creds *repoCredentials, getters getter.Providers) (getter.Getter, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart URL %q: %v", u, err)
+	}
+	newGetter, err := getters.ByScheme(parsed.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return newGetter(u, creds.certFile, creds.keyFile, creds.caFile)
+}
+
+// fetchChart returns the archive of chart version cv, served from the
+// chart cache when possible. Downloaded archives are checked against the
+// digest of the repository index, if any, before being cached.
+func (c *Controller) fetchChart(repoURL string, creds *repoCredentials, cv *repo.ChartVersion, chartURL string, g getter.Getter) ([]byte, error) {
+	key := chartCacheKey(repoURL, cv.Name, cv.Version, cv.Digest, creds.cacheKey())
+	return c.fetchArchive(key, repoURL, chartURL, cv.Digest, g)
+}
+
+// fetchArchive returns the archive at u cached under key, downloading it
+// if needed. Downloaded archives are checked against digest, if any,
+// before being cached. Metrics are recorded under repoURL.
+func (c *Controller) fetchArchive(key, repoURL, u, digest string, g getter.Getter) ([]byte, error) {
+	if data, ok := c.charts.Get(key); ok {
+		chartCacheRequests.WithLabelValues("hit").Inc()
+		glog.V(2).Infof("Using cached chart %s", u)
+		return data, nil
+	}
+	chartCacheRequests.WithLabelValues("miss").Inc()
+
+	data, err := downloadArchive(repoURL, u, g)
+	if err != nil {
+		return nil, err
+	}
+	if digest != "" {
+		if got := archiveDigest(data); got != digest {
+			return nil, fmt.Errorf("digest of %s is %s, expected %s", u, got, digest)
+		}
+	}
+	c.charts.Add(key, data)
+	return data, nil
+}
+
+// downloadArchive downloads the archive at u, recording metrics under
+// repoURL
+func downloadArchive(repoURL, u string, g getter.Getter) ([]byte, error) {
+	glog.Infof("Downloading %s ...", u)
+	start := time.Now()
+	buf, err := g.Get(u)
+	chartDownloadDuration.WithLabelValues(repoURL).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	chartDownloadBytes.WithLabelValues(repoURL).Add(float64(buf.Len()))
+	return buf.Bytes(), nil
+}
+
+// archiveDigest returns the SHA-256 of data in hex, as found in
+// repository indexes
+func archiveDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const (
+	driftReasonMissing  = "Missing"
+	driftReasonModified = "Modified"
+
+	// maxDriftedFields bounds the fields reported per drifted resource
+	maxDriftedFields = 10
+)
+
+// liveResources reads and writes the resources of releases, see
+// dynamicResources
+type liveResources interface {
+	Get(obj *unstructured.Unstructured) (*unstructured.Unstructured, error)
+	Apply(obj *unstructured.Unstructured) error
+}
+
+// driftCheckEnabled returns whether the resources of hr are periodically
+// compared to its release manifest
+func (c *Controller) driftCheckEnabled(hr *v1.HelmRelease) bool {
+	return c.config.DriftInterval > 0 && hr.Spec.DriftPolicy != v1.DriftPolicyIgnore
+}
+
+// listMergeKeys are the fields identifying the items of the lists of
+// Kubernetes objects, such as containers, ports and volume mounts, in
+// order of preference. They are those of strategic merge patches, the
+// live list being matched by them regardless of the order of its items.
+var listMergeKeys = []string{"mountPath", "devicePath", "containerPort", "port", "name"}
+
+// quantityParents are the fields whose values are resource quantities,
+// which the API server writes back in their canonical form, such as "1"
+// for "1000m"
+var quantityParents = map[string]bool{
+	"limits":               true,
+	"requests":             true,
+	"hard":                 true,
+	"capacity":             true,
+	"max":                  true,
+	"min":                  true,
+	"default":              true,
+	"defaultRequest":       true,
+	"maxLimitRequestRatio": true,
+}
+
+// driftedFields returns the paths of the fields set in desired which
+// differ in live, sorted. Fields only set in live, such as defaults filled
+// in by the API server, are not drift. Neither are the status and the
+// metadata other than labels and annotations, nor the items of keyed
+// lists only found in live, such as injected sidecar containers.
+func driftedFields(desired, live interface{}, path string) []string {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			if len(d) == 0 && live == nil {
+				return nil
+			}
+			return []string{path}
+		}
+		var fields []string
+		for k, v := range d {
+			p := path + "." + k
+			switch {
+			case path == "" && (k == "status" || k == "stringData"):
+				continue
+			case path == ".metadata" && k != "labels" && k != "annotations":
+				continue
+			}
+			fields = append(fields, driftedFields(v, l[k], p)...)
+		}
+		sort.Strings(fields)
+		return fields
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			if len(d) == 0 && live == nil {
+				return nil
+			}
+			return []string{path}
+		}
+		if key := listMergeKey(d); key != "" {
+			return diffKeyedList(d, l, path, key)
+		}
+		if len(d) != len(l) {
+			return []string{path}
+		}
+		var fields []string
+		for i := range d {
+			fields = append(fields, driftedFields(d[i], l[i], fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return fields
+	case nil:
+		return nil
+	default:
+		if live == nil {
+			return []string{path}
+		}
+		if isQuantity(path) {
+			if equal, ok := equalQuantities(desired, live); ok {
+				if !equal {
+					return []string{path}
+				}
+				return nil
+			}
+		}
+		// Numbers may be decoded as int64 or float64 depending on how
+		// they were written
+		if !reflect.DeepEqual(desired, live) && fmt.Sprint(desired) != fmt.Sprint(live) {
+			return []string{path}
+		}
+		return nil
+	}
+}
+
+// listMergeKey returns the first of listMergeKeys set to a distinct
+// scalar in every item of list, if any
+func listMergeKey(list []interface{}) string {
+	if len(list) == 0 {
+		return ""
+	}
+	for _, key := range listMergeKeys {
+		seen := map[string]bool{}
+		for _, item := range list {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return ""
+			}
+			v := m[key]
+			switch v.(type) {
+			case nil, map[string]interface{}, []interface{}:
+			default:
+				seen[fmt.Sprint(v)] = true
+			}
+		}
+		if len(seen) == len(list) {
+			return key
+		}
+	}
+	return ""
+}
+
+// diffKeyedList compares the items of desired to the items of live with
+// the same value of key, which may be in any order
+func diffKeyedList(desired, live []interface{}, path, key string) []string {
+	byKey := map[string]interface{}{}
+	for _, item := range live {
+		if m, ok := item.(map[string]interface{}); ok && m[key] != nil {
+			byKey[fmt.Sprint(m[key])] = item
+		}
+	}
+	var fields []string
+	for _, item := range desired {
+		v := fmt.Sprint(item.(map[string]interface{})[key])
+		p := fmt.Sprintf("%s[%s=%s]", path, key, v)
+		l, ok := byKey[v]
+		if !ok {
+			fields = append(fields, p)
+			continue
+		}
+		fields = append(fields, driftedFields(item, l, p)...)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// isQuantity returns whether the field at path holds a resource quantity
+func isQuantity(path string) bool {
+	fields := strings.Split(path, ".")
+	if n := len(fields); n >= 2 && quantityParents[fields[n-2]] {
+		return true
+	}
+	return strings.HasSuffix(path, ".sizeLimit")
+}
+
+// equalQuantities compares desired and live as resource quantities, ok
+// being false if either isn't one
+func equalQuantities(desired, live interface{}) (equal, ok bool) {
+	d, err := resource.ParseQuantity(fmt.Sprint(desired))
+	if err != nil {
+		return false, false
+	}
+	l, err := resource.ParseQuantity(fmt.Sprint(live))
+	if err != nil {
+		return false, false
+	}
+	return d.Cmp(l) == 0, true
+}
+
+// checkDrift compares the live resources of the release of hr to its
+// manifest, every --driftInterval. Drifted resources are reported in the
+// status and the Drifted condition, and re-applied from the manifest
+// with the Correct drift policy. Errors don't fail the HelmRelease, the
+// release itself being up to date.
+func (c *Controller) checkDrift(key string, hr *v1.HelmRelease) error {
+	if c.resources == nil || !c.driftCheckEnabled(hr) {
+		return nil
+	}
+	interval := c.config.DriftInterval
+	next := interval
+	defer func() { c.queue.AddAfter(key, next) }()
+	if d := hr.Status.Drift; d != nil {
+		if elapsed := time.Since(d.LastCheckTime.Time); elapsed < interval {
+			next = interval - elapsed
+			return nil
+		}
+	}
+
+	rlsName := releaseName(hr.Namespace, hr.Name)
+	rel, err := c.backend.Content(hr.Namespace, rlsName)
+	if err != nil {
+		return fmt.Errorf("unable to fetch release %s: %v", rlsName, err)
+	}
+	objs, err := parseManifest(rel.GetManifest(), rel.GetNamespace())
+	if err != nil {
+		return fmt.Errorf("unable to parse the manifest of release %s: %v", rlsName, err)
+	}
+
+	var drifted []v1.DriftedResource
+	var toApply []*unstructured.Unstructured
+	for _, obj := range objs {
+		// Get clears the namespace of cluster scoped resources
+		live, err := c.resources.Get(obj)
+		res := v1.DriftedResource{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		}
+		switch {
+		case apierrors.IsNotFound(err):
+			res.Reason = driftReasonMissing
+		case err != nil:
+			return fmt.Errorf("unable to get %s %s: %v", obj.GetKind(), obj.GetName(), err)
+		default:
+			fields := driftedFields(obj.Object, live.Object, "")
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) > maxDriftedFields {
+				fields = fields[:maxDriftedFields]
+			}
+			res.Reason = driftReasonModified
+			res.Fields = fields
+		}
+		drifted = append(drifted, res)
+		toApply = append(toApply, obj)
+	}
+
+	hr = hr.DeepCopy()
+	hr.Status.Drift = &v1.DriftStatus{LastCheckTime: metav1.Now(), Resources: drifted}
+	if len(drifted) == 0 {
+		setCondition(&hr.Status, v1.HelmReleaseConditionDrifted, corev1.ConditionFalse, reasonNoDrift, "")
+		_, err := c.updateStatus(hr)
+		return err
+	}
+
+	names := make([]string, len(drifted))
+	for i, res := range drifted {
+		names[i] = fmt.Sprintf("%s %s (%s)", res.Kind, res.Name, strings.ToLower(res.Reason))
+	}
+	msg := fmt.Sprintf("%d resources drifted from release %s: %s", len(drifted), rlsName, strings.Join(names, ", "))
+	glog.Infof("HelmRelease %s: %s", key, msg)
+	setCondition(&hr.Status, v1.HelmReleaseConditionDrifted, corev1.ConditionTrue, reasonDriftDetected, msg)
+
+	if hr.Spec.DriftPolicy == v1.DriftPolicyCorrect {
+		for _, obj := range toApply {
+			if err := c.resources.Apply(obj); err != nil {
+				// The check is retried, the status being left as is
+				c.warningEventf(key, hr, reasonDriftCorrectFailed, "Failed to re-apply %s %s: %v", obj.GetKind(), obj.GetName(), err)
+				return err
+			}
+		}
+		c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonDriftCorrected, "Re-applied %d drifted resources of release %s", len(toApply), rlsName)
+		setCondition(&hr.Status, v1.HelmReleaseConditionDrifted, corev1.ConditionFalse, reasonDriftCorrected, msg)
+	} else {
+		c.warningEventf(key, hr, reasonDriftDetected, "%s", msg)
+	}
+	_, err = c.updateStatus(hr)
+	return err
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+func TestDriftedFields(t *testing.T) {
+	const desired = `
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+        args: [--verbose, --port=8080]
+        ports:
+        - containerPort: 8080
+        - containerPort: 9090
+        resources:
+          limits:
+            cpu: 1000m
+            memory: 1Gi
+          requests:
+            cpu: 0.5
+        volumeMounts:
+        - name: data
+          mountPath: /data
+        - name: data
+          mountPath: /cache
+          subPath: cache
+`
+	tests := []struct {
+		name string
+		live string
+		want []string
+	}{
+		{
+			name: "canonical quantities and extra fields",
+			live: `
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+        imagePullPolicy: IfNotPresent
+        args: [--verbose, --port=8080]
+        ports:
+        - containerPort: 8080
+          protocol: TCP
+        - containerPort: 9090
+          protocol: TCP
+        resources:
+          limits:
+            cpu: "1"
+            memory: 1Gi
+          requests:
+            cpu: 500m
+        volumeMounts:
+        - name: data
+          mountPath: /data
+        - name: data
+          mountPath: /cache
+          subPath: cache
+`,
+		},
+		{
+			name: "reordered items and injected sidecar",
+			live: `
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: istio-proxy
+        image: proxy:1.0
+      - name: app
+        image: app:1.0
+        args: [--verbose, --port=8080]
+        ports:
+        - containerPort: 9090
+        - containerPort: 8080
+        resources:
+          limits:
+            cpu: "1"
+            memory: 1Gi
+          requests:
+            cpu: 500m
+        volumeMounts:
+        - name: istio-envoy
+          mountPath: /etc/istio/proxy
+        - name: data
+          mountPath: /cache
+          subPath: cache
+        - name: data
+          mountPath: /data
+`,
+		},
+		{
+			name: "modified items",
+			live: `
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:2.0
+        args: [--port=8080, --verbose]
+        ports:
+        - containerPort: 8080
+        resources:
+          limits:
+            cpu: "2"
+            memory: 1Gi
+          requests:
+            cpu: 500m
+        volumeMounts:
+        - name: data
+          mountPath: /data
+        - name: data
+          mountPath: /cache
+          subPath: other
+`,
+			want: []string{
+				".spec.template.spec.containers[name=app].args[0]",
+				".spec.template.spec.containers[name=app].args[1]",
+				".spec.template.spec.containers[name=app].image",
+				".spec.template.spec.containers[name=app].ports[containerPort=9090]",
+				".spec.template.spec.containers[name=app].resources.limits.cpu",
+				".spec.template.spec.containers[name=app].volumeMounts[mountPath=/cache].subPath",
+			},
+		},
+		{
+			name: "missing container",
+			live: `
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: other
+`,
+			want: []string{".spec.template.spec.containers[name=app]"},
+		},
+	}
+	var d map[string]interface{}
+	if err := yaml.Unmarshal([]byte(desired), &d); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l map[string]interface{}
+			if err := yaml.Unmarshal([]byte(tt.live), &l); err != nil {
+				t.Fatal(err)
+			}
+			if got := driftedFields(d, l, ""); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("driftedFields() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// Reasons of the events recorded on HelmReleases, also used for the
+// reasons of status conditions
+const (
+	reasonChartDownloaded    = "ChartDownloaded"
+	reasonDownloadFailed     = "DownloadFailed"
+	reasonInstalled          = "Installed"
+	reasonInstallFailed      = "InstallFailed"
+	reasonUpgraded           = "Upgraded"
+	reasonUpgradeFailed      = "UpgradeFailed"
+	reasonDeleted            = "Deleted"
+	reasonDeleteFailed       = "DeleteFailed"
+	reasonRolledBack         = "RolledBack"
+	reasonRollbackFailed     = "RollbackFailed"
+	reasonPaused             = "Paused"
+	reasonVersionUpdated     = "VersionUpdated"
+	reasonVerified           = "Verified"
+	reasonVerifyFailed       = "VerifyFailed"
+	reasonNotVerified        = "NotVerified"
+	reasonWaiting            = "DependencyNotReady"
+	reasonDependencyCycle    = "DependencyCycle"
+	reasonDependencyReady    = "DependenciesReady"
+	reasonNoDrift            = "NoDrift"
+	reasonDriftDetected      = "DriftDetected"
+	reasonDriftCorrected     = "DriftCorrected"
+	reasonDriftCorrectFailed = "DriftCorrectionFailed"
+	reasonResourcesReady     = "ResourcesReady"
+	reasonResourcesNotReady  = "ResourcesNotReady"
+	reasonDeploying          = "Deploying"
+	reasonTested             = "Tested"
+	reasonTestFailed         = "TestFailed"
+)
+
+// warningEventf records a Warning event on hr, unless the previous
+// attempt at key failed with the same reason and message, so that
+// retries don't flood the HelmRelease with identical events.
+func (c *Controller) warningEventf(key string, hr *v1.HelmRelease, reason, messageFmt string, args ...interface{}) {
+	msg := fmt.Sprintf(messageFmt, args...)
+	last := reason + ": " + msg
+
+	c.lastWarningsLock.Lock()
+	prev, seen := c.lastWarnings[key]
+	c.lastWarnings[key] = last
+	c.lastWarningsLock.Unlock()
+
+	if seen && prev == last {
+		return
+	}
+	c.recorder.Event(hr, corev1.EventTypeWarning, reason, msg)
+}
+
+// forgetWarnings resets the deduplication of warning events for key,
+// once it has been reconciled successfully
+func (c *Controller) forgetWarnings(key string) {
+	c.lastWarningsLock.Lock()
+	delete(c.lastWarnings, key)
+	c.lastWarningsLock.Unlock()
+}
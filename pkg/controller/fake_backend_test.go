@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// FakeCall is a call made to a FakeBackend
+type FakeCall struct {
+	Method  string
+	Release string
+	// Options holds the options passed to the call, if any
+	Options interface{}
+}
+
+// FakeBackend is an in-memory ReleaseBackend recording the calls made
+// to it, for use in tests
+type FakeBackend struct {
+	mu sync.Mutex
+	// Releases holds the revisions of each release, oldest first
+	Releases map[string][]*release.Release
+	// Errors makes the methods it contains fail with the given error
+	Errors map[string]error
+	// TestResults are the results of the release tests, none by default
+	TestResults []*release.TestRun
+	// Calls are the calls made so far
+	Calls []FakeCall
+}
+
+// NewFakeBackend returns a FakeBackend without releases
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		Releases: map[string][]*release.Release{},
+		Errors:   map[string]error{},
+	}
+}
+
+// Methods returns the methods called so far, in order
+func (f *FakeBackend) Methods() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	methods := make([]string, len(f.Calls))
+	for i, call := range f.Calls {
+		methods[i] = call.Method
+	}
+	return methods
+}
+
+func (f *FakeBackend) record(method, name string, opts interface{}) error {
+	f.Calls = append(f.Calls, FakeCall{Method: method, Release: name, Options: opts})
+	return f.Errors[method]
+}
+
+func (f *FakeBackend) current(name string) (*release.Release, error) {
+	revs := f.Releases[name]
+	if len(revs) == 0 {
+		return nil, ErrReleaseNotFound
+	}
+	return revs[len(revs)-1], nil
+}
+
+// push adds a new deployed revision of the release name, superseding
+// the current one
+func (f *FakeBackend) push(name, namespace string, ch *chart.Chart, values []byte) *release.Release {
+	revs := f.Releases[name]
+	if len(revs) > 0 {
+		revs[len(revs)-1].Info.Status.Code = release.Status_SUPERSEDED
+	}
+	rel := &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Version:   int32(len(revs) + 1),
+		Chart:     ch,
+		Config:    &chart.Config{Raw: string(values)},
+		Info: &release.Info{
+			Status: &release.Status{Code: release.Status_DEPLOYED},
+		},
+	}
+	f.Releases[name] = append(revs, rel)
+	return rel
+}
+
+// Install implements ReleaseBackend
+func (f *FakeBackend) Install(ch *chart.Chart, opts InstallOptions) (*release.Release, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Install", opts.Name, opts); err != nil {
+		return nil, err
+	}
+	if len(f.Releases[opts.Name]) > 0 {
+		return nil, fmt.Errorf("a release named %s already exists", opts.Name)
+	}
+	return f.push(opts.Name, opts.Namespace, ch, opts.Values), nil
+}
+
+// Upgrade implements ReleaseBackend
+func (f *FakeBackend) Upgrade(namespace, name string, ch *chart.Chart, opts UpgradeOptions) (*release.Release, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Upgrade", name, opts); err != nil {
+		return nil, err
+	}
+	cur, err := f.current(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.push(name, cur.Namespace, ch, opts.Values), nil
+}
+
+// Delete implements ReleaseBackend
+func (f *FakeBackend) Delete(namespace, name string, opts DeleteOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Delete", name, opts); err != nil {
+		return err
+	}
+	cur, err := f.current(name)
+	if err != nil {
+		return err
+	}
+	if opts.Purge {
+		delete(f.Releases, name)
+	} else {
+		cur.Info.Status.Code = release.Status_DELETED
+	}
+	return nil
+}
+
+// History implements ReleaseBackend
+func (f *FakeBackend) History(namespace, name string, max int32) ([]*release.Release, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("History", name, nil); err != nil {
+		return nil, err
+	}
+	revs := f.Releases[name]
+	if len(revs) == 0 {
+		return nil, ErrReleaseNotFound
+	}
+	var history []*release.Release
+	for i := len(revs) - 1; i >= 0 && int32(len(history)) < max; i-- {
+		history = append(history, revs[i])
+	}
+	return history, nil
+}
+
+// Status implements ReleaseBackend
+func (f *FakeBackend) Status(namespace, name string) (*release.Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Status", name, nil); err != nil {
+		return nil, err
+	}
+	cur, err := f.current(name)
+	if err != nil {
+		return nil, err
+	}
+	return cur.Info.Status, nil
+}
+
+// Content implements ReleaseBackend
+func (f *FakeBackend) Content(namespace, name string) (*release.Release, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Content", name, nil); err != nil {
+		return nil, err
+	}
+	return f.current(name)
+}
+
+// Rollback implements ReleaseBackend
+func (f *FakeBackend) Rollback(namespace, name string, opts RollbackOptions) (*release.Release, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Rollback", name, opts); err != nil {
+		return nil, err
+	}
+	revs := f.Releases[name]
+	if opts.Revision < 1 || int(opts.Revision) > len(revs) {
+		return nil, fmt.Errorf("release %s has no revision %d", name, opts.Revision)
+	}
+	target := revs[opts.Revision-1]
+	return f.push(name, target.Namespace, target.Chart, []byte(target.Config.GetRaw())), nil
+}
+
+// Test implements ReleaseBackend, the tests of the fake releases have
+// TestResults, reported as running then done to opts.Progress
+func (f *FakeBackend) Test(namespace, name string, opts TestOptions) (*release.TestSuite, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Test", name, opts); err != nil {
+		return nil, err
+	}
+	cur, err := f.current(name)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Progress != nil {
+		for _, run := range f.TestResults {
+			opts.Progress(&release.TestRun{Name: run.Name, Status: release.TestRun_RUNNING})
+			opts.Progress(run)
+		}
+	}
+	cur.Info.Status.LastTestSuiteRun = &release.TestSuite{Results: f.TestResults}
+	return cur.Info.Status.LastTestSuiteRun, nil
+}
+
+// Ping implements ReleaseBackend
+func (f *FakeBackend) Ping() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record("Ping", "", nil)
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+func hasFinalizer(hr *v1.HelmRelease) bool {
+	for _, f := range hr.Finalizers {
+		if f == v1.ReleaseFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// addFinalizer attaches the release finalizer to hr and returns the
+// updated object.
+func (c *Controller) addFinalizer(hr *v1.HelmRelease) (*v1.HelmRelease, error) {
+	hrCopy := hr.DeepCopy()
+	hrCopy.Finalizers = append(hrCopy.Finalizers, v1.ReleaseFinalizer)
+	return c.clientset.HelmV1().HelmReleases(hrCopy.Namespace).Update(hrCopy)
+}
+
+// finalizeRelease deletes the release of a HelmRelease being deleted
+// according to its deletion policy, then removes the release finalizer.
+func (c *Controller) finalizeRelease(key string, hr *v1.HelmRelease) error {
+	if !hasFinalizer(hr) {
+		return nil
+	}
+
+	rlsName := releaseName(hr.Namespace, hr.Name)
+	switch hr.Spec.DeletionPolicy {
+	case v1.DeletionPolicyOrphan:
+		glog.Infof("HelmRelease %s/%s is being deleted, leaving release %s installed", hr.Namespace, hr.Name, rlsName)
+		c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonDeleted, "Orphaned release %s", rlsName)
+	case v1.DeletionPolicyPurge, v1.DeletionPolicyKeep, "":
+		purge := hr.Spec.DeletionPolicy != v1.DeletionPolicyKeep
+		glog.Infof("HelmRelease %s/%s is being deleted, uninstalling release %s (purge=%v)", hr.Namespace, hr.Name, rlsName, purge)
+		err := c.backend.Delete(hr.Namespace, rlsName, DeleteOptions{
+			Purge:        purge,
+			Timeout:      releaseTimeout(hr),
+			DisableHooks: hr.Spec.DisableHooks,
+		})
+		if err != nil && err != ErrReleaseNotFound {
+			c.warningEventf(key, hr, reasonDeleteFailed, "Failed to delete release %s: %v", rlsName, err)
+			return err
+		}
+		c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonDeleted, "Deleted release %s (purge=%v)", rlsName, purge)
+	default:
+		return fmt.Errorf("unknown deletion policy %q", hr.Spec.DeletionPolicy)
+	}
+
+	hrCopy := hr.DeepCopy()
+	hrCopy.Finalizers = hrCopy.Finalizers[:0]
+	for _, f := range hr.Finalizers {
+		if f != v1.ReleaseFinalizer {
+			hrCopy.Finalizers = append(hrCopy.Finalizers, f)
+		}
+	}
+	_, err := c.clientset.HelmV1().HelmReleases(hrCopy.Namespace).Update(hrCopy)
+	return err
+}
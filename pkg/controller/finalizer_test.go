@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+func TestFinalizeRelease(t *testing.T) {
+
+	const otherFinalizer = "example.com/other"
+	now := metav1.Now()
+
+	tests := []struct {
+		name       string
+		policy     v1.DeletionPolicy
+		finalizers []string
+		deleteErr  error
+
+		wantErr        bool
+		wantCalls      []FakeCall
+		wantStatus     release.Status_Code
+		wantReleases   int
+		wantFinalizers []string
+	}{
+		{
+			name:       "purges by default",
+			finalizers: []string{v1.ReleaseFinalizer, otherFinalizer},
+			wantCalls: []FakeCall{{Method: "Delete", Release: testRelease,
+				Options: DeleteOptions{Purge: true, Timeout: defaultTimeout}}},
+			wantFinalizers: []string{otherFinalizer},
+		},
+		{
+			name:       "purges",
+			policy:     v1.DeletionPolicyPurge,
+			finalizers: []string{v1.ReleaseFinalizer},
+			wantCalls: []FakeCall{{Method: "Delete", Release: testRelease,
+				Options: DeleteOptions{Purge: true, Timeout: defaultTimeout}}},
+		},
+		{
+			name:       "keeps release history",
+			policy:     v1.DeletionPolicyKeep,
+			finalizers: []string{v1.ReleaseFinalizer},
+			wantCalls: []FakeCall{{Method: "Delete", Release: testRelease,
+				Options: DeleteOptions{Timeout: defaultTimeout}}},
+			wantStatus:   release.Status_DELETED,
+			wantReleases: 1,
+		},
+		{
+			name:         "orphans release",
+			policy:       v1.DeletionPolicyOrphan,
+			finalizers:   []string{v1.ReleaseFinalizer},
+			wantStatus:   release.Status_DEPLOYED,
+			wantReleases: 1,
+		},
+		{
+			name:       "ignores gone release",
+			finalizers: []string{v1.ReleaseFinalizer},
+			deleteErr:  ErrReleaseNotFound,
+			wantCalls: []FakeCall{{Method: "Delete", Release: testRelease,
+				Options: DeleteOptions{Purge: true, Timeout: defaultTimeout}}},
+			wantStatus:   release.Status_DEPLOYED,
+			wantReleases: 1,
+		},
+		{
+			name:       "keeps finalizer when deletion fails",
+			finalizers: []string{v1.ReleaseFinalizer},
+			deleteErr:  errors.New("boom"),
+			wantErr:    true,
+			wantCalls: []FakeCall{{Method: "Delete", Release: testRelease,
+				Options: DeleteOptions{Purge: true, Timeout: defaultTimeout}}},
+			wantStatus:     release.Status_DEPLOYED,
+			wantReleases:   1,
+			wantFinalizers: []string{v1.ReleaseFinalizer},
+		},
+		{
+			name:           "rejects unknown policy",
+			policy:         "Archive",
+			finalizers:     []string{v1.ReleaseFinalizer},
+			wantErr:        true,
+			wantStatus:     release.Status_DEPLOYED,
+			wantReleases:   1,
+			wantFinalizers: []string{v1.ReleaseFinalizer},
+		},
+		{
+			name:           "leaves release without finalizer",
+			finalizers:     []string{otherFinalizer},
+			wantStatus:     release.Status_DEPLOYED,
+			wantReleases:   1,
+			wantFinalizers: []string{otherFinalizer},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease("")
+			hr.DeletionTimestamp = &now
+			hr.Finalizers = tt.finalizers
+			hr.Spec.DeletionPolicy = tt.policy
+			backend := NewFakeBackend()
+			backend.Releases[testRelease] = deployedRelease()
+			if tt.deleteErr != nil {
+				backend.Errors["Delete"] = tt.deleteErr
+			}
+			c, clientset := newTestController(t, backend, hr)
+
+			err := c.finalizeRelease(testNamespace+"/"+testName, hr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("finalizeRelease() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(backend.Calls, tt.wantCalls) {
+				t.Errorf("backend calls = %+v, want %+v", backend.Calls, tt.wantCalls)
+			}
+			if got := len(backend.Releases); got != tt.wantReleases {
+				t.Errorf("backend has %d releases, want %d", got, tt.wantReleases)
+			}
+			if revs := backend.Releases[testRelease]; len(revs) > 0 {
+				if got := revs[0].Info.Status.Code; got != tt.wantStatus {
+					t.Errorf("release status = %v, want %v", got, tt.wantStatus)
+				}
+			}
+
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Finalizers)+len(tt.wantFinalizers) > 0 && !reflect.DeepEqual(got.Finalizers, tt.wantFinalizers) {
+				t.Errorf("finalizers = %v, want %v", got.Finalizers, tt.wantFinalizers)
+			}
+		})
+	}
+}
@@ -2,34 +2,166 @@ package controller
 
 import (
 	"flag"
+	"fmt"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/spf13/pflag"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/helm/helmpath"
 )
 
-var (
-	defaultRepoURL string
-	resyncDuration int64
-	kubeconfig     *rest.Config
-	settings       environment.EnvSettings
-)
+// Config holds the settings of a Controller. DefaultConfig returns the
+// defaults, which AddFlags lets the command line override.
+type Config struct {
+	// Helm is the Helm environment: home directory, Tiller host and
+	// namespace
+	Helm environment.EnvSettings
+
+	// Kubeconfig, Context and Master select the API server, see
+	// RestConfig
+	Kubeconfig string
+	Context    string
+	Master     string
+
+	DefaultRepoURL string
+	// Resync is the resync period of the informers, in seconds
+	Resync  int64
+	Workers int
+	// Backend is the release backend, tiller or helm3
+	Backend string
+	// PollInterval is how often version ranges are resolved again, for
+	// HelmReleases not setting spec.pollInterval
+	PollInterval time.Duration
+	// Keyring is the keyring charts are verified against, for
+	// HelmReleases not setting spec.keyring
+	Keyring            string
+	ChartCacheDir      string
+	ChartCacheMemoryMB int64
+	ChartCacheDiskMB   int64
+	RepoIndexRefresh   time.Duration
+	// DriftInterval is the interval between drift checks, 0 disabling
+	// them
+	DriftInterval time.Duration
+
+	// MetricsAddr is the address the Prometheus metrics are served on
+	MetricsAddr string
+	// LeaderElect enables leader election, see RunWithLeaderElection
+	LeaderElect    bool
+	LeaderElection LeaderElectionConfig
+}
 
-func init() {
-	settings.AddFlags(pflag.CommandLine)
+// LeaderElectionConfig holds the settings of the leader election lock
+type LeaderElectionConfig struct {
+	Namespace     string
+	Name          string
+	ResourceLock  string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
 
-	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+// DefaultConfig returns the default settings of a Controller
+func DefaultConfig() Config {
+	return Config{
+		Helm: environment.EnvSettings{
+			Home:                    helmpath.Home(environment.DefaultHelmHome),
+			TillerNamespace:         "kube-system",
+			TillerConnectionTimeout: 300,
+		},
+		DefaultRepoURL:     "https://kubernetes-charts.storage.googleapis.com",
+		Resync:             300,
+		Workers:            1,
+		Backend:            "tiller",
+		PollInterval:       10 * time.Minute,
+		Keyring:            "/keyring/pubring.gpg",
+		ChartCacheMemoryMB: 64,
+		ChartCacheDiskMB:   512,
+		RepoIndexRefresh:   time.Minute,
+		DriftInterval:      10 * time.Minute,
+		MetricsAddr:        ":8080",
+		LeaderElection: LeaderElectionConfig{
+			Namespace:     defaultLeaderElectNamespace(),
+			Name:          "helm-crd-controller",
+			ResourceLock:  resourcelock.ConfigMapsResourceLock,
+			LeaseDuration: 15 * time.Second,
+			RenewDeadline: 10 * time.Second,
+			RetryPeriod:   2 * time.Second,
+		},
+	}
+}
+
+// AddFlags registers the controller flags, along with the Helm and glog
+// ones, on fs, defaulting to the current settings of cfg. It must be
+// called before fs is parsed.
+func (cfg *Config) AddFlags(fs *pflag.FlagSet) {
+	cfg.Helm.AddFlags(fs)
+
+	fs.AddGoFlagSet(flag.CommandLine)
 	flag.CommandLine.Parse([]string{})
-	pflag.Lookup("logtostderr").Value.Set("true")
-	settings.Init(pflag.CommandLine)
+	fs.Lookup("logtostderr").Value.Set("true")
+	cfg.Helm.Init(fs)
 
-	pflag.StringVar(&defaultRepoURL, "defaultRepoURL", "https://kubernetes-charts.storage.googleapis.com", "default repository url")
-	pflag.Int64Var(&resyncDuration, "resync", 300, "resync cache duration")
-	pflag.Parse()
+	fs.StringVar(&cfg.Kubeconfig, "kubeconfig", cfg.Kubeconfig, "path to a kubeconfig, only required if running out of cluster")
+	fs.StringVar(&cfg.Context, "context", cfg.Context, "name of the kubeconfig context to use")
+	fs.StringVar(&cfg.Master, "master", cfg.Master, "address of the Kubernetes API server, overrides the one in the kubeconfig")
+
+	fs.StringVar(&cfg.DefaultRepoURL, "defaultRepoURL", cfg.DefaultRepoURL, "default repository url")
+	fs.Int64Var(&cfg.Resync, "resync", cfg.Resync, "resync cache duration")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "number of HelmReleases processed concurrently")
+	fs.StringVar(&cfg.Backend, "backend", cfg.Backend, "release backend, tiller or helm3 to manage releases without Tiller")
+	fs.StringVar(&cfg.ChartCacheDir, "chartCacheDir", cfg.ChartCacheDir, "directory charts are cached in, defaults to $HELM_HOME/cache/charts")
+	fs.Int64Var(&cfg.ChartCacheMemoryMB, "chartCacheMemoryMB", cfg.ChartCacheMemoryMB, "size in MiB of the in-memory chart cache, 0 to disable")
+	fs.Int64Var(&cfg.ChartCacheDiskMB, "chartCacheDiskMB", cfg.ChartCacheDiskMB, "size in MiB of the on-disk chart cache, 0 to disable")
+	fs.DurationVar(&cfg.RepoIndexRefresh, "repoIndexRefresh", cfg.RepoIndexRefresh, "interval between checks of the repository indexes for changes")
+	fs.StringVar(&cfg.MetricsAddr, "metricsAddr", cfg.MetricsAddr, "address to serve Prometheus metrics on, empty to disable")
+	fs.StringVar(&cfg.Keyring, "keyring", cfg.Keyring, "default keyring charts are verified against, for HelmReleases not setting spec.keyring")
+	fs.DurationVar(&cfg.PollInterval, "pollInterval", cfg.PollInterval, "default interval to check repositories for new chart versions matching a version range")
+	fs.DurationVar(&cfg.DriftInterval, "driftInterval", cfg.DriftInterval, "interval between checks of the release resources for drift from the release manifests, 0 to disable")
+	fs.BoolVar(&cfg.LeaderElect, "leaderElect", cfg.LeaderElect, "run leader election so that only one of several controller replicas is active")
+	fs.StringVar(&cfg.LeaderElection.Namespace, "leaderElectNamespace", cfg.LeaderElection.Namespace, "namespace of the leader election lock")
+	fs.StringVar(&cfg.LeaderElection.Name, "leaderElectName", cfg.LeaderElection.Name, "name of the leader election lock")
+	fs.StringVar(&cfg.LeaderElection.ResourceLock, "leaderElectResourceLock", cfg.LeaderElection.ResourceLock, "type of the leader election lock, configmaps or endpoints")
+	fs.DurationVar(&cfg.LeaderElection.LeaseDuration, "leaderElectLeaseDuration", cfg.LeaderElection.LeaseDuration, "duration non-leader replicas wait before trying to acquire an unrenewed lease")
+	fs.DurationVar(&cfg.LeaderElection.RenewDeadline, "leaderElectRenewDeadline", cfg.LeaderElection.RenewDeadline, "duration the leader retries renewing its lease before giving up")
+	fs.DurationVar(&cfg.LeaderElection.RetryPeriod, "leaderElectRetryPeriod", cfg.LeaderElection.RetryPeriod, "duration between leader election attempts")
+}
 
-	var err error
-	if kubeconfig, err = rest.InClusterConfig(); err != nil {
-		glog.Fatal(err.Error())
+// Validate returns an error if the settings of cfg can't run a Controller
+func (cfg *Config) Validate() error {
+	switch {
+	case cfg.Workers < 1:
+		return fmt.Errorf("--workers must be at least 1, got %d", cfg.Workers)
+	case cfg.PollInterval <= 0:
+		return fmt.Errorf("--pollInterval must be positive, got %s", cfg.PollInterval)
+	case cfg.Resync < 0:
+		return fmt.Errorf("--resync must not be negative, got %d", cfg.Resync)
+	case cfg.DriftInterval < 0:
+		return fmt.Errorf("--driftInterval must not be negative, got %s", cfg.DriftInterval)
+	case cfg.Helm.Home == "":
+		return fmt.Errorf("the Helm home is required")
 	}
+	if cfg.LeaderElect {
+		switch cfg.LeaderElection.ResourceLock {
+		case resourcelock.ConfigMapsResourceLock, resourcelock.EndpointsResourceLock:
+		default:
+			return fmt.Errorf("unsupported leader election resource lock %q, must be one of %q or %q",
+				cfg.LeaderElection.ResourceLock, resourcelock.ConfigMapsResourceLock, resourcelock.EndpointsResourceLock)
+		}
+	}
+	return nil
+}
+
+// RestConfig returns the configuration to reach the Kubernetes API server
+// with, as selected by --kubeconfig, --context and --master. The usual
+// kubeconfig loading rules apply, falling back to the in-cluster
+// configuration when no kubeconfig is found.
+func (cfg *Config) RestConfig() (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = cfg.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cfg.Context}
+	overrides.ClusterInfo.Server = cfg.Master
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
 }
@@ -0,0 +1,371 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// gitTimeout bounds each git command, so that unreachable repositories
+// don't block a worker
+const gitTimeout = 5 * time.Minute
+
+var commitRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// defaultGitSchemes are the schemes of the Git repositories that may be
+// fetched. Local paths and file:// URLs would expose the filesystem of
+// the controller, and plain http the credentials.
+var defaultGitSchemes = []string{"https", "ssh", "git"}
+
+// scpLikeURL matches the user@host:path syntax of SSH repositories
+var scpLikeURL = regexp.MustCompile(`^\w[\w.-]*@[\w.-]+:`)
+
+// checkGitURL returns an error unless url is a repository of one of
+// schemes
+func checkGitURL(url string, schemes []string) error {
+	if scpLikeURL.MatchString(url) {
+		return nil
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return fmt.Errorf("invalid git url %q: %v", url, err)
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("git url %q must be a %s url", url, strings.Join(schemes, ", "))
+}
+
+// gitCredentials configures the git command to access a repository. SSH
+// keys are materialized as files under dir, which is removed by cleanup
+// once the reconcile is done.
+type gitCredentials struct {
+	env []string
+	dir string
+	// cacheKey identifies the credentials in the chart cache, empty
+	// without any, as with repoCredentials.cacheKey
+	cacheKey string
+}
+
+// gitCredentials returns the credentials held by the Secret ref in ns,
+// or none if ref is nil. They are passed to git through its environment
+// rather than its arguments, which any user of the node can read.
+func (c *Controller) gitCredentials(ns string, ref *corev1.LocalObjectReference) (*gitCredentials, error) {
+	creds := &gitCredentials{
+		env: append(os.Environ(),
+			"GIT_TERMINAL_PROMPT=0",
+			// Redirects and submodules are held to the same schemes
+			"GIT_ALLOW_PROTOCOL="+strings.Join(c.gitSchemes, ":"),
+		),
+	}
+	if ref == nil {
+		return creds, nil
+	}
+	obj, exists, err := c.secretInformer.GetIndexer().GetByKey(ns + "/" + ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("git credentials secret %s/%s not found", ns, ref.Name)
+	}
+	data := obj.(*corev1.Secret).Data
+	h := sha256.New()
+	for _, key := range []string{corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey, "identity", "known_hosts"} {
+		fmt.Fprintf(h, "%d:%s", len(data[key]), data[key])
+	}
+	creds.cacheKey = hex.EncodeToString(h.Sum(nil))
+
+	if username, password := data[corev1.BasicAuthUsernameKey], data[corev1.BasicAuthPasswordKey]; password != nil {
+		if username == nil {
+			// Tokens are accepted with any user name
+			username = []byte("git")
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte(string(username) + ":" + string(password)))
+		creds.env = append(creds.env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+auth,
+		)
+	}
+
+	if identity, ok := data["identity"]; ok {
+		// Without known hosts, host keys can't be checked
+		knownHosts, ok := data["known_hosts"]
+		if !ok {
+			return nil, fmt.Errorf("git credentials secret %s/%s holds an identity without known_hosts", ns, ref.Name)
+		}
+		dir, err := ioutil.TempDir("", "helm-crd-git-")
+		if err != nil {
+			return nil, err
+		}
+		creds.dir = dir
+		identityFile := filepath.Join(dir, "identity")
+		if err := ioutil.WriteFile(identityFile, identity, 0600); err != nil {
+			creds.cleanup()
+			return nil, err
+		}
+		knownHostsFile := filepath.Join(dir, "known_hosts")
+		if err := ioutil.WriteFile(knownHostsFile, knownHosts, 0600); err != nil {
+			creds.cleanup()
+			return nil, err
+		}
+		creds.env = append(creds.env, fmt.Sprintf(
+			"GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes",
+			identityFile, knownHostsFile))
+	}
+	return creds, nil
+}
+
+// cleanup removes the SSH files materialized for these credentials
+func (g *gitCredentials) cleanup() {
+	if g.dir == "" {
+		return
+	}
+	if err := os.RemoveAll(g.dir); err != nil {
+		glog.Warningf("Unable to remove %s: %v", g.dir, err)
+	}
+}
+
+// run runs git with args in dir, and returns its trimmed output
+func (g *gitCredentials) run(dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = g.env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitRef returns the ref of the repository checked out for ref, or the
+// commit if ref pins one
+func gitRef(ref *v1.GitReference) (name, commit string, err error) {
+	if ref == nil {
+		return "HEAD", "", nil
+	}
+	set := 0
+	for _, v := range []string{ref.Branch, ref.Tag, ref.Commit} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", "", fmt.Errorf("git ref must set only one of branch, tag and commit")
+	}
+	switch {
+	case ref.Branch != "":
+		return "refs/heads/" + ref.Branch, "", nil
+	case ref.Tag != "":
+		return "refs/tags/" + ref.Tag, "", nil
+	case ref.Commit != "":
+		if !commitRegexp.MatchString(ref.Commit) {
+			return "", "", fmt.Errorf("git commit %q is not a full SHA-1", ref.Commit)
+		}
+		return "", ref.Commit, nil
+	}
+	return "HEAD", "", nil
+}
+
+// lsRemote returns the commit ref points to in the repository at url.
+// Annotated tags are peeled to their commit.
+func (g *gitCredentials) lsRemote(url, ref string) (string, error) {
+	out, err := g.run("", "ls-remote", "--", url, ref, ref+"^{}")
+	if err != nil {
+		return "", err
+	}
+	var commit string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[1] {
+		case ref + "^{}":
+			return fields[0], nil
+		case ref:
+			commit = fields[0]
+		}
+	}
+	if commit == "" {
+		return "", fmt.Errorf("%s not found in git repository %s", strings.TrimPrefix(ref, "refs/"), url)
+	}
+	return commit, nil
+}
+
+// gitChart resolves the commit of the Git source of hr. Branches and
+// tags are resolved with ls-remote, the repository being only fetched
+// when the chart is loaded.
+func (c *Controller) gitChart(hr *v1.HelmRelease) (*chartSource, error) {
+	git := hr.Spec.Source.Git
+	if git.URL == "" {
+		return nil, fmt.Errorf("source.git.url is required")
+	}
+	if err := checkGitURL(git.URL, c.gitSchemes); err != nil {
+		return nil, err
+	}
+	ref, commit, err := gitRef(git.Ref)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := c.gitCredentials(hr.Namespace, git.SecretRef)
+	if err != nil {
+		return nil, err
+	}
+	if commit == "" {
+		if commit, err = creds.lsRemote(git.URL, ref); err != nil {
+			creds.cleanup()
+			return nil, err
+		}
+	}
+
+	s := &chartSource{
+		url:     git.URL,
+		source:  &v1.SourceStatus{URL: git.URL, Revision: commit},
+		cleanup: creds.cleanup,
+	}
+	s.load = func() (*chart.Chart, error) {
+		data, err := c.fetchGitChart(git, creds, ref, s.source)
+		if err != nil {
+			return nil, err
+		}
+		return s.loadArchive(data)
+	}
+	return s, nil
+}
+
+// checkSymlinks returns an error if chartDir, in the clone at dir, is
+// reached through a symlink or holds any. chartutil.LoadDir follows them,
+// which would let repositories pack files of the controller, such as its
+// service account token, into charts.
+func checkSymlinks(dir, chartDir string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	realChartDir, err := filepath.EvalSymlinks(chartDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, chartDir)
+	if err != nil {
+		return err
+	}
+	if realChartDir != filepath.Join(realDir, rel) {
+		return fmt.Errorf("%s is a symlink", rel)
+	}
+	return filepath.Walk(chartDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			rel, _ := filepath.Rel(dir, path)
+			return fmt.Errorf("%s is a symlink", rel)
+		}
+		return nil
+	})
+}
+
+// fetchGitChart returns the archive of the chart directory of git at the
+// commit of source, served from the chart cache when possible, that is
+// when it was fetched with the same credentials before. Otherwise
+// the repository is fetched in the helm home and the directory packaged.
+// If ref moved since it was resolved, source is updated to the fetched
+// commit.
+func (c *Controller) fetchGitChart(git *v1.GitSource, creds *gitCredentials, ref string, source *v1.SourceStatus) ([]byte, error) {
+	if data, ok := c.charts.Get(chartCacheKey(git.URL, git.Path, source.Revision, "", creds.cacheKey)); ok {
+		chartCacheRequests.WithLabelValues("hit").Inc()
+		glog.V(2).Infof("Using cached chart %s of %s at %s", git.Path, git.URL, source.Revision)
+		return data, nil
+	}
+	chartCacheRequests.WithLabelValues("miss").Inc()
+
+	base := filepath.Join(c.config.Helm.Home.Cache(), "git")
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(base, "clone-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	glog.Infof("Fetching %s from %s ...", source.Revision, git.URL)
+	if _, err := creds.run(dir, "init", "-q"); err != nil {
+		return nil, err
+	}
+	if ref != "" {
+		if _, err := creds.run(dir, "fetch", "-q", "--depth=1", "--", git.URL, ref); err != nil {
+			return nil, err
+		}
+		if _, err := creds.run(dir, "checkout", "-q", "FETCH_HEAD"); err != nil {
+			return nil, err
+		}
+	} else {
+		// Servers don't necessarily allow fetching commits by SHA-1, so
+		// all branches and tags are fetched
+		if _, err := creds.run(dir, "fetch", "-q", "--", git.URL, "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"); err != nil {
+			return nil, err
+		}
+		if _, err := creds.run(dir, "checkout", "-q", source.Revision); err != nil {
+			return nil, err
+		}
+	}
+	commit, err := creds.run(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	source.Revision = commit
+
+	// The chart would otherwise include the repository
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		return nil, err
+	}
+	chartDir := filepath.Join(dir, filepath.Clean("/"+git.Path))
+	if err := checkSymlinks(dir, chartDir); err != nil {
+		return nil, fmt.Errorf("failed to load chart directory %q: %v", git.Path, err)
+	}
+	ch, err := chartutil.LoadDir(chartDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart directory %q: %v", git.Path, err)
+	}
+	archiveDir, err := ioutil.TempDir(base, "package-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(archiveDir)
+	archive, err := chartutil.Save(ch, archiveDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(archive)
+	if err != nil {
+		return nil, err
+	}
+	c.charts.Add(chartCacheKey(git.URL, git.Path, commit, "", creds.cacheKey), data)
+	return data, nil
+}
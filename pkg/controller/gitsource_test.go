@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckGitURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "https://git.example.com/charts.git"},
+		{url: "ssh://git@git.example.com/charts.git"},
+		{url: "git://git.example.com/charts.git"},
+		{url: "git@git.example.com:platform/charts.git"},
+		{url: "http://git.example.com/charts.git", wantErr: true},
+		{url: "file:///etc", wantErr: true},
+		{url: "/etc", wantErr: true},
+		{url: "ext::sh -c touch% /tmp/pwned", wantErr: true},
+		{url: "--upload-pack=touch /tmp/pwned", wantErr: true},
+		{url: "-oProxyCommand=sh@host:path", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := checkGitURL(tt.url, defaultGitSchemes); (err != nil) != tt.wantErr {
+			t.Errorf("checkGitURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGitCredentials(t *testing.T) {
+	secret := func(name string, data map[string]string) *corev1.Secret {
+		s := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name},
+			Data:       map[string][]byte{},
+		}
+		for k, v := range data {
+			s.Data[k] = []byte(v)
+		}
+		return s
+	}
+	c, _ := newTestController(t, NewFakeBackend())
+	for _, s := range []*corev1.Secret{
+		secret("https", map[string]string{"username": "user", "password": "token"}),
+		secret("ssh", map[string]string{"identity": "key", "known_hosts": "hosts"}),
+		secret("ssh-unchecked", map[string]string{"identity": "key"}),
+	} {
+		c.secretInformer.GetIndexer().Add(s)
+	}
+
+	creds, err := c.gitCredentials(testNamespace, &corev1.LocalObjectReference{Name: "https"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.cacheKey == "" {
+		t.Errorf("credentials have no cache key")
+	}
+	env := strings.Join(creds.env, "\n")
+	if !strings.Contains(env, "GIT_CONFIG_KEY_0=http.extraHeader\nGIT_CONFIG_VALUE_0=Authorization: Basic dXNlcjp0b2tlbg==") {
+		t.Errorf("HTTPS credentials are not in the environment: %v", creds.env)
+	}
+
+	creds, err = c.gitCredentials(testNamespace, &corev1.LocalObjectReference{Name: "ssh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer creds.cleanup()
+	if env := strings.Join(creds.env, "\n"); !strings.Contains(env, "StrictHostKeyChecking=yes") {
+		t.Errorf("host keys are not checked: %v", creds.env)
+	}
+	// Charts fetched with other credentials are not served from the cache
+	if anonymous, _ := c.gitCredentials(testNamespace, nil); creds.cacheKey == "" || creds.cacheKey == anonymous.cacheKey {
+		t.Errorf("cache key %q doesn't identify the credentials", creds.cacheKey)
+	}
+
+	if _, err := c.gitCredentials(testNamespace, &corev1.LocalObjectReference{Name: "ssh-unchecked"}); err == nil {
+		t.Errorf("identity without known_hosts was accepted")
+	}
+}
+
+func TestCheckSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"charts/mychart/templates", "charts/linked/templates", "other"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "charts/mychart/Chart.yaml"), []byte("name: mychart"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"charts/linked/templates/token": "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		"charts/outside":                "../other",
+		"README.md":                     "charts/mychart/Chart.yaml",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		// Symlinks elsewhere in the repository don't matter
+		{path: "charts/mychart"},
+		{path: "charts/linked", wantErr: true},
+		{path: "charts/outside", wantErr: true},
+		{path: "charts/missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := checkSymlinks(dir, filepath.Join(dir, tt.path)); (err != nil) != tt.wantErr {
+			t.Errorf("checkSymlinks(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const (
+	kindDeployment  = "Deployment"
+	kindStatefulSet = "StatefulSet"
+	kindDaemonSet   = "DaemonSet"
+	kindJob         = "Job"
+	kindPVC         = "PersistentVolumeClaim"
+)
+
+// healthGroups are the API groups the resources whose health is tracked
+// may be declared in, by kind
+var healthGroups = map[string][]string{
+	kindDeployment:  {"apps", "extensions"},
+	kindStatefulSet: {"apps"},
+	kindDaemonSet:   {"apps", "extensions"},
+	kindJob:         {"batch"},
+	kindPVC:         {""},
+}
+
+// newHealthInformers returns the informers of the resources whose health
+// is tracked, by kind
+func newHealthInformers(kubeClientset kubernetes.Interface) map[string]cache.SharedIndexInformer {
+	return map[string]cache.SharedIndexInformer{
+		kindDeployment:  newCoreInformer(kubeClientset.AppsV1().RESTClient(), "deployments", &appsv1.Deployment{}),
+		kindStatefulSet: newCoreInformer(kubeClientset.AppsV1().RESTClient(), "statefulsets", &appsv1.StatefulSet{}),
+		kindDaemonSet:   newCoreInformer(kubeClientset.AppsV1().RESTClient(), "daemonsets", &appsv1.DaemonSet{}),
+		kindJob:         newCoreInformer(kubeClientset.BatchV1().RESTClient(), "jobs", &batchv1.Job{}),
+		kindPVC:         newCoreInformer(kubeClientset.CoreV1().RESTClient(), "persistentvolumeclaims", &corev1.PersistentVolumeClaim{}),
+	}
+}
+
+// trackedResources are the resources of a release revision whose health
+// is tracked
+type trackedResources struct {
+	revision int32
+	objs     []*unstructured.Unstructured
+}
+
+// healthTracker remembers the resources of the release of each
+// HelmRelease, so that the HelmReleases are requeued when they change
+// without fetching the release manifest every time
+type healthTracker struct {
+	lock     sync.Mutex
+	releases map[string]trackedResources
+	// owners holds the keys of the HelmReleases owning each resource
+	owners map[string]map[string]bool
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		releases: map[string]trackedResources{},
+		owners:   map[string]map[string]bool{},
+	}
+}
+
+func healthResourceKey(kind, ns, name string) string {
+	return kind + "/" + ns + "/" + name
+}
+
+// resources returns the tracked resources of the HelmRelease key at the
+// given revision
+func (t *healthTracker) resources(key string, revision int32) ([]*unstructured.Unstructured, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	r, ok := t.releases[key]
+	if !ok || r.revision != revision {
+		return nil, false
+	}
+	return r.objs, true
+}
+
+// set tracks objs as the resources of the HelmRelease key
+func (t *healthTracker) set(key string, revision int32, objs []*unstructured.Unstructured) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.forgetLocked(key)
+	t.releases[key] = trackedResources{revision: revision, objs: objs}
+	for _, obj := range objs {
+		k := healthResourceKey(obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		if t.owners[k] == nil {
+			t.owners[k] = map[string]bool{}
+		}
+		t.owners[k][key] = true
+	}
+}
+
+// forget stops tracking the resources of the HelmRelease key
+func (t *healthTracker) forget(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.forgetLocked(key)
+}
+
+func (t *healthTracker) forgetLocked(key string) {
+	for _, obj := range t.releases[key].objs {
+		k := healthResourceKey(obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		delete(t.owners[k], key)
+		if len(t.owners[k]) == 0 {
+			delete(t.owners, k)
+		}
+	}
+	delete(t.releases, key)
+}
+
+// ownersOf returns the keys of the HelmReleases owning a resource
+func (t *healthTracker) ownersOf(kind, ns, name string) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var keys []string
+	for key := range t.owners[healthResourceKey(kind, ns, name)] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// healthEventHandler requeues the health of the HelmReleases owning a
+// resource of the given kind whenever it changes
+func (c *Controller) healthEventHandler(kind string) cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		meta, err := apimeta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		for _, key := range c.health.ownersOf(kind, meta.GetNamespace(), meta.GetName()) {
+			c.healthQueue.Add(key)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) { enqueue(newObj) },
+		DeleteFunc: enqueue,
+	}
+}
+
+func (c *Controller) runHealthWorker() {
+	for c.processNextHealth() {
+		// continue looping
+	}
+}
+
+func (c *Controller) processNextHealth() bool {
+	key, quit := c.healthQueue.Get()
+	if quit {
+		return false
+	}
+	defer c.healthQueue.Done(key)
+
+	if err := c.syncHealth(key.(string)); err != nil {
+		runtime.HandleError(fmt.Errorf("Error checking the health of %s: %v", key, err))
+		c.healthQueue.AddRateLimited(key)
+		return true
+	}
+	c.healthQueue.Forget(key)
+	return true
+}
+
+// syncHealth records the readiness of the resources of the release of
+// the HelmRelease key in its status and Healthy condition
+func (c *Controller) syncHealth(key string) error {
+	obj, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		c.health.forget(key)
+		return nil
+	}
+	hr := obj.(*v1.HelmRelease)
+	if hr.DeletionTimestamp != nil || hr.Status.Revision == 0 {
+		c.health.forget(key)
+		return nil
+	}
+
+	objs, ok := c.health.resources(key, hr.Status.Revision)
+	if !ok {
+		rlsName := releaseName(hr.Namespace, hr.Name)
+		rel, err := c.backend.Content(hr.Namespace, rlsName)
+		if err == ErrReleaseNotFound {
+			c.health.forget(key)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to fetch release %s: %v", rlsName, err)
+		}
+		all, err := parseManifest(rel.GetManifest(), rel.GetNamespace())
+		if err != nil {
+			return fmt.Errorf("unable to parse the manifest of release %s: %v", rlsName, err)
+		}
+		objs = nil
+		for _, obj := range all {
+			if healthTracked(obj) {
+				objs = append(objs, obj)
+			}
+		}
+		c.health.set(key, hr.Status.Revision, objs)
+	}
+
+	resources := make([]v1.ResourceStatus, 0, len(objs))
+	var notReady []string
+	for _, obj := range objs {
+		res, err := c.resourceHealth(obj)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, res)
+		if !res.Ready {
+			notReady = append(notReady, fmt.Sprintf("%s %s: %s", res.Kind, res.Name, res.Message))
+		}
+	}
+
+	updated := hr.DeepCopy()
+	updated.Status.Resources = resources
+	if len(notReady) == 0 {
+		setCondition(&updated.Status, v1.HelmReleaseConditionHealthy, corev1.ConditionTrue, reasonResourcesReady,
+			fmt.Sprintf("%d resources ready", len(resources)))
+	} else {
+		setCondition(&updated.Status, v1.HelmReleaseConditionHealthy, corev1.ConditionFalse, reasonResourcesNotReady,
+			strings.Join(notReady, "; "))
+	}
+	if reflect.DeepEqual(updated.Status, hr.Status) {
+		return nil
+	}
+
+	if was, now := conditionStatus(&hr.Status, v1.HelmReleaseConditionHealthy), conditionStatus(&updated.Status, v1.HelmReleaseConditionHealthy); was != now {
+		glog.Infof("HelmRelease %s: Healthy=%s", key, now)
+		if now == corev1.ConditionTrue {
+			c.recorder.Eventf(updated, corev1.EventTypeNormal, reasonResourcesReady, "All %d resources of the release are ready", len(resources))
+		} else if was == corev1.ConditionTrue {
+			c.recorder.Eventf(updated, corev1.EventTypeWarning, reasonResourcesNotReady, "%s", strings.Join(notReady, "; "))
+		}
+	}
+	_, err = c.updateStatus(updated)
+	return err
+}
+
+// healthTracked returns whether the health of obj is tracked
+func healthTracked(obj *unstructured.Unstructured) bool {
+	groups, ok := healthGroups[obj.GetKind()]
+	if !ok {
+		return false
+	}
+	group := obj.GroupVersionKind().Group
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceHealth returns the readiness of obj, from the informer of its
+// kind
+func (c *Controller) resourceHealth(obj *unstructured.Unstructured) (v1.ResourceStatus, error) {
+	res := v1.ResourceStatus{
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+	live, exists, err := c.healthInformers[res.Kind].GetIndexer().GetByKey(res.Namespace + "/" + res.Name)
+	if err != nil {
+		return res, err
+	}
+	if !exists {
+		res.Message = "not found"
+		return res, nil
+	}
+	switch live := live.(type) {
+	case *appsv1.Deployment:
+		res.Ready, res.Message = deploymentHealth(live)
+	case *appsv1.StatefulSet:
+		res.Ready, res.Message = statefulSetHealth(live)
+	case *appsv1.DaemonSet:
+		res.Ready, res.Message = daemonSetHealth(live)
+	case *batchv1.Job:
+		res.Ready, res.Message = jobHealth(live)
+	case *corev1.PersistentVolumeClaim:
+		res.Ready, res.Message = pvcHealth(live)
+	}
+	return res, nil
+}
+
+const rolloutPending = "waiting for the rollout to be observed"
+
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// deploymentHealth follows kubectl rollout status
+func deploymentHealth(d *appsv1.Deployment) (bool, string) {
+	if d.Status.ObservedGeneration < d.Generation {
+		return false, rolloutPending
+	}
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Sprintf("rollout exceeded its progress deadline: %s", cond.Message)
+		}
+	}
+	replicas := replicasOrDefault(d.Spec.Replicas)
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		return false, fmt.Sprintf("%d/%d replicas updated", d.Status.UpdatedReplicas, replicas)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return false, fmt.Sprintf("%d old replicas pending termination", d.Status.Replicas-d.Status.UpdatedReplicas)
+	}
+	msg := fmt.Sprintf("%d/%d replicas available", d.Status.AvailableReplicas, replicas)
+	return d.Status.AvailableReplicas >= replicas, msg
+}
+
+func statefulSetHealth(s *appsv1.StatefulSet) (bool, string) {
+	if s.Status.ObservedGeneration < s.Generation {
+		return false, rolloutPending
+	}
+	replicas := replicasOrDefault(s.Spec.Replicas)
+	if s.Status.ReadyReplicas < replicas {
+		return false, fmt.Sprintf("%d/%d replicas ready", s.Status.ReadyReplicas, replicas)
+	}
+	// Partitioned rolling updates are left to complete by hand
+	if ru := s.Spec.UpdateStrategy.RollingUpdate; s.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType &&
+		(ru == nil || ru.Partition == nil || *ru.Partition == 0) &&
+		s.Status.UpdateRevision != "" && s.Status.UpdateRevision != s.Status.CurrentRevision {
+		return false, fmt.Sprintf("%d/%d replicas updated", s.Status.UpdatedReplicas, replicas)
+	}
+	return true, fmt.Sprintf("%d/%d replicas ready", s.Status.ReadyReplicas, replicas)
+}
+
+func daemonSetHealth(d *appsv1.DaemonSet) (bool, string) {
+	if d.Status.ObservedGeneration < d.Generation {
+		return false, rolloutPending
+	}
+	desired := d.Status.DesiredNumberScheduled
+	if d.Spec.UpdateStrategy.Type == appsv1.RollingUpdateDaemonSetStrategyType && d.Status.UpdatedNumberScheduled < desired {
+		return false, fmt.Sprintf("%d/%d pods updated", d.Status.UpdatedNumberScheduled, desired)
+	}
+	msg := fmt.Sprintf("%d/%d pods available", d.Status.NumberAvailable, desired)
+	return d.Status.NumberAvailable >= desired, msg
+}
+
+func jobHealth(j *batchv1.Job) (bool, string) {
+	for _, cond := range j.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return true, "complete"
+		case batchv1.JobFailed:
+			return false, fmt.Sprintf("failed: %s", cond.Message)
+		}
+	}
+	return false, fmt.Sprintf("%d active, %d succeeded", j.Status.Active, j.Status.Succeeded)
+}
+
+func pvcHealth(p *corev1.PersistentVolumeClaim) (bool, string) {
+	phase := p.Status.Phase
+	if phase == "" {
+		phase = corev1.ClaimPending
+	}
+	return phase == corev1.ClaimBound, string(phase)
+}
@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/evanphx/json-patch"
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/helm/pkg/releaseutil"
+)
+
+// resourcePolicyAnno set to "keep" leaves a resource in place when it is
+// removed from a release, as with Helm
+const resourcePolicyAnno = "helm.sh/resource-policy"
+
+// installOrder is the order in which Helm creates the resources of a
+// release, other kinds come last
+var installOrder = []string{
+	"Namespace",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"ServiceAccount",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+func kindRank(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
+
+// parseManifest parses the resources of a release manifest, in install
+// order. Resources without a namespace are put in namespace, which is
+// cleared again for cluster scoped resources by dynamicResources.client.
+func parseManifest(manifest, namespace string) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		data, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return nil, err
+		}
+		if string(data) == "null" {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(namespace)
+		}
+		objs = append(objs, obj)
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		ri, rj := kindRank(objs[i].GetKind()), kindRank(objs[j].GetKind())
+		if ri != rj {
+			return ri < rj
+		}
+		return resourceKey(objs[i]) < resourceKey(objs[j])
+	})
+	return objs, nil
+}
+
+func resourceKey(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	return strings.Join([]string{gvk.Group, gvk.Kind, obj.GetNamespace(), obj.GetName()}, "/")
+}
+
+// createPatch returns a patch from original to target, strategic for the
+// built-in types and a JSON merge patch otherwise, like Helm does
+func createPatch(original, target *unstructured.Unstructured) ([]byte, types.PatchType, error) {
+	oldData, err := json.Marshal(original)
+	if err != nil {
+		return nil, "", err
+	}
+	newData, err := json.Marshal(target)
+	if err != nil {
+		return nil, "", err
+	}
+	versioned, err := scheme.Scheme.New(target.GroupVersionKind())
+	if err != nil {
+		patch, err := jsonpatch.CreateMergePatch(oldData, newData)
+		return patch, types.MergePatchType, err
+	}
+	patch, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, versioned)
+	return patch, types.StrategicMergePatchType, err
+}
+
+// applyManifest moves the resources of a release from those of
+// oldManifest to those of newManifest: new resources are created,
+// changed ones patched, and those no longer part of the release deleted.
+// With force, resources that can't be patched are recreated.
+func (b *helm3Backend) applyManifest(namespace, oldManifest, newManifest string, force bool) error {
+	oldObjs, err := parseManifest(oldManifest, namespace)
+	if err != nil {
+		return fmt.Errorf("unable to parse the current manifest: %v", err)
+	}
+	newObjs, err := parseManifest(newManifest, namespace)
+	if err != nil {
+		return fmt.Errorf("unable to parse the new manifest: %v", err)
+	}
+
+	originals := map[string]*unstructured.Unstructured{}
+	for _, obj := range oldObjs {
+		originals[resourceKey(obj)] = obj
+	}
+	for _, obj := range newObjs {
+		key := resourceKey(obj)
+		original := originals[key]
+		delete(originals, key)
+		if err := b.applyResource(original, obj, force); err != nil {
+			return fmt.Errorf("%s %q: %v", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+
+	for i := len(oldObjs) - 1; i >= 0; i-- {
+		obj := oldObjs[i]
+		if _, removed := originals[resourceKey(obj)]; !removed {
+			continue
+		}
+		if err := b.deleteResource(obj); err != nil {
+			return fmt.Errorf("%s %q: %v", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func (b *helm3Backend) applyResource(original, target *unstructured.Unstructured, force bool) error {
+	client, err := b.resources.client(target)
+	if err != nil {
+		return err
+	}
+	_, err = client.Get(target.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = client.Create(target)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if original == nil {
+		return fmt.Errorf("already exists and is not part of the release")
+	}
+	original.SetNamespace(target.GetNamespace())
+
+	patch, patchType, err := createPatch(original, target)
+	if err != nil {
+		return err
+	}
+	if string(patch) == "{}" {
+		return nil
+	}
+	_, err = client.Patch(target.GetName(), patchType, patch)
+	if err != nil && force {
+		glog.Infof("Unable to patch %s %s, recreating it: %v", target.GetKind(), target.GetName(), err)
+		if err := client.Delete(target.GetName(), &metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		_, err = client.Create(target)
+	}
+	return err
+}
+
+func (b *helm3Backend) deleteResource(obj *unstructured.Unstructured) error {
+	if obj.GetAnnotations()[resourcePolicyAnno] == "keep" {
+		glog.Infof("Keeping %s %s as per its %s annotation", obj.GetKind(), obj.GetName(), resourcePolicyAnno)
+		return nil
+	}
+	client, err := b.resources.client(obj)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = client.Delete(obj.GetName(), &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteManifest deletes the resources of a release manifest, in reverse
+// install order
+func (b *helm3Backend) deleteManifest(namespace, manifest string) error {
+	objs, err := parseManifest(manifest, namespace)
+	if err != nil {
+		return err
+	}
+	for i := len(objs) - 1; i >= 0; i-- {
+		if err := b.deleteResource(objs[i]); err != nil {
+			return fmt.Errorf("%s %q: %v", objs[i].GetKind(), objs[i].GetName(), err)
+		}
+	}
+	return nil
+}
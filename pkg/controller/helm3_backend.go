@@ -0,0 +1,381 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/engine"
+	"k8s.io/helm/pkg/hooks"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/releaseutil"
+	"k8s.io/helm/pkg/timeconv"
+	"k8s.io/helm/pkg/version"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const notesFile = "NOTES.txt"
+
+// helm3Backend is a ReleaseBackend rendering and applying charts in
+// process, without Tiller. Release records are kept as Secrets in the
+// release namespace, in the format of Helm 3. Hooks are not run, see
+// checkHooks, resources are not waited for, and release tests are not
+// supported.
+type helm3Backend struct {
+	kubeClient      kubernetes.Interface
+	discovery       discovery.CachedDiscoveryInterface
+	resources       *dynamicResources
+	tillerNamespace string
+}
+
+func newHelm3Backend(kubeconfig *rest.Config, kubeClient kubernetes.Interface, tillerNamespace string) *helm3Backend {
+	disc := cached.NewMemCacheClient(kubeClient.Discovery())
+	return &helm3Backend{
+		kubeClient:      kubeClient,
+		discovery:       disc,
+		resources:       newDynamicResources(kubeconfig, disc),
+		tillerNamespace: tillerNamespace,
+	}
+}
+
+// capabilities returns what charts may know of the cluster, as Tiller
+// does
+func (b *helm3Backend) capabilities() (*chartutil.Capabilities, error) {
+	sv, err := b.discovery.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := b.discovery.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	return &chartutil.Capabilities{
+		APIVersions:   chartutil.NewVersionSet(metav1.ExtractGroupVersions(groups)...),
+		KubeVersion:   sv,
+		TillerVersion: version.GetVersionProto(),
+	}, nil
+}
+
+// render renders ch into revision of the release name
+func (b *helm3Backend) render(ch *chart.Chart, name, namespace string, values []byte, revision int32) (*release.Release, error) {
+	caps, err := b.capabilities()
+	if err != nil {
+		return nil, err
+	}
+	if ch.Metadata.KubeVersion != "" {
+		k8sVersion := strings.Split(caps.KubeVersion.String(), "+")[0]
+		if !version.IsCompatibleRange(ch.Metadata.KubeVersion, k8sVersion) {
+			return nil, fmt.Errorf("chart requires kubernetesVersion: %s which is incompatible with Kubernetes %s", ch.Metadata.KubeVersion, k8sVersion)
+		}
+	}
+
+	config := &chart.Config{Raw: string(values)}
+	if err := chartutil.ProcessRequirementsEnabled(ch, config); err != nil {
+		return nil, err
+	}
+	if err := chartutil.ProcessRequirementsImportValues(ch); err != nil {
+		return nil, err
+	}
+	now := timeconv.Now()
+	vals, err := chartutil.ToRenderValuesCaps(ch, config, chartutil.ReleaseOptions{
+		Name:      name,
+		Time:      now,
+		Namespace: namespace,
+		IsInstall: revision == 1,
+		IsUpgrade: revision > 1,
+		Revision:  int(revision),
+	}, caps)
+	if err != nil {
+		return nil, err
+	}
+	files, err := engine.New().Render(ch, vals)
+	if err != nil {
+		return nil, err
+	}
+
+	rel := &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Chart:     ch,
+		Config:    config,
+		Version:   revision,
+		Info: &release.Info{
+			FirstDeployed: now,
+			LastDeployed:  now,
+			Status:        &release.Status{Code: release.Status_DEPLOYED},
+		},
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var manifest bytes.Buffer
+	for _, p := range paths {
+		content := files[p]
+		switch {
+		case strings.HasSuffix(p, notesFile):
+			// Only the notes of the parent chart are kept
+			if p == path.Join(ch.Metadata.Name, "templates", notesFile) {
+				rel.Info.Status.Notes = content
+			}
+			continue
+		case strings.HasPrefix(path.Base(p), "_"), strings.TrimSpace(content) == "":
+			continue
+		}
+		for _, doc := range releaseutil.SplitManifests(content) {
+			var head releaseutil.SimpleHead
+			if err := yaml.Unmarshal([]byte(doc), &head); err != nil {
+				return nil, fmt.Errorf("YAML parse error on %s: %v", p, err)
+			}
+			if head.Version != "" && !caps.APIVersions.Has(head.Version) {
+				return nil, fmt.Errorf("apiVersion %q in %s is not available", head.Version, p)
+			}
+			if head.Metadata != nil && head.Metadata.Annotations[hooks.HookAnno] != "" {
+				rel.Hooks = append(rel.Hooks, parseHook(p, doc, &head))
+				continue
+			}
+			manifest.WriteString("\n---\n# Source: " + p + "\n")
+			manifest.WriteString(doc)
+		}
+	}
+	rel.Manifest = manifest.String()
+	return rel, nil
+}
+
+func parseHook(p, doc string, head *releaseutil.SimpleHead) *release.Hook {
+	h := &release.Hook{
+		Name:     head.Metadata.Name,
+		Kind:     head.Kind,
+		Path:     p,
+		Manifest: doc,
+	}
+	if w, err := strconv.Atoi(head.Metadata.Annotations[hooks.HookWeightAnno]); err == nil {
+		h.Weight = int32(w)
+	}
+	for _, event := range strings.Split(head.Metadata.Annotations[hooks.HookAnno], ",") {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if event == hooks.ReleaseTestSuccess {
+			event = "test"
+		}
+		for e, s := range helm3HookEvents {
+			if s == event {
+				h.Events = append(h.Events, e)
+			}
+		}
+	}
+	for _, policy := range strings.Split(head.Metadata.Annotations[hooks.HookDeleteAnno], ",") {
+		policy = strings.TrimSpace(policy)
+		for p, s := range helm3HookDeletePolicies {
+			if s == policy {
+				h.DeletePolicies = append(h.DeletePolicies, p)
+			}
+		}
+	}
+	return h
+}
+
+// checkHooks returns an error if rel has hooks for events, unless they
+// are disabled. Hooks are recorded but not run, so that releases relying
+// on them, say on a migration Job, fail rather than get half deployed.
+func checkHooks(rel *release.Release, disableHooks bool, events ...release.Hook_Event) error {
+	if disableHooks {
+		return nil
+	}
+	var names []string
+	for _, h := range rel.GetHooks() {
+		for _, e := range h.Events {
+			if hasHookEvent(events, e) {
+				names = append(names, h.Kind+"/"+h.Name)
+				break
+			}
+		}
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("chart hooks %s are not run by the helm3 backend, set spec.disableHooks to skip them", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func hasHookEvent(events []release.Hook_Event, e release.Hook_Event) bool {
+	for _, event := range events {
+		if event == e {
+			return true
+		}
+	}
+	return false
+}
+
+// deploy applies rel over the current revision cur, if any, then records
+// it. Failed revisions are recorded too.
+func (b *helm3Backend) deploy(cur, rel *release.Release, force bool, description string) (*release.Release, error) {
+	oldManifest := ""
+	if cur != nil && cur.GetInfo().GetStatus().GetCode() != release.Status_DELETED {
+		oldManifest = cur.Manifest
+	}
+	if err := b.applyManifest(rel.Namespace, oldManifest, rel.Manifest, force); err != nil {
+		rel.Info.Status.Code = release.Status_FAILED
+		rel.Info.Description = fmt.Sprintf("Release %q failed: %v", rel.Name, err)
+		if recErr := b.storeRecord(rel); recErr != nil {
+			glog.Warningf("Unable to record release %s: %v", rel.Name, recErr)
+		}
+		return nil, err
+	}
+	rel.Info.Description = description
+	if cur != nil {
+		rel.Info.FirstDeployed = cur.GetInfo().GetFirstDeployed()
+		cur.Info.Status.Code = release.Status_SUPERSEDED
+		if err := b.storeRecord(cur); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.storeRecord(rel); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+func (b *helm3Backend) Install(ch *chart.Chart, opts InstallOptions) (*release.Release, error) {
+	rel, err := b.render(ch, opts.Name, opts.Namespace, opts.Values, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHooks(rel, opts.DisableHooks, release.Hook_PRE_INSTALL, release.Hook_POST_INSTALL); err != nil {
+		return nil, err
+	}
+	return b.deploy(nil, rel, false, "Install complete")
+}
+
+func (b *helm3Backend) Upgrade(namespace, name string, ch *chart.Chart, opts UpgradeOptions) (*release.Release, error) {
+	revs, err := b.records(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	cur := revs[len(revs)-1]
+	rel, err := b.render(ch, name, cur.Namespace, opts.Values, cur.Version+1)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHooks(rel, opts.DisableHooks, release.Hook_PRE_UPGRADE, release.Hook_POST_UPGRADE); err != nil {
+		return nil, err
+	}
+	return b.deploy(cur, rel, opts.Force, "Upgrade complete")
+}
+
+func (b *helm3Backend) Delete(namespace, name string, opts DeleteOptions) error {
+	revs, err := b.records(namespace, name)
+	if err != nil {
+		return err
+	}
+	cur := revs[len(revs)-1]
+	if cur.GetInfo().GetStatus().GetCode() != release.Status_DELETED {
+		if err := checkHooks(cur, opts.DisableHooks, release.Hook_PRE_DELETE, release.Hook_POST_DELETE); err != nil {
+			return err
+		}
+		if err := b.deleteManifest(cur.Namespace, cur.Manifest); err != nil {
+			return err
+		}
+	}
+	if opts.Purge {
+		for _, rel := range revs {
+			if err := b.deleteRecord(rel); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	cur.Info.Status.Code = release.Status_DELETED
+	cur.Info.Deleted = timeconv.Now()
+	cur.Info.Description = "Uninstallation complete"
+	return b.storeRecord(cur)
+}
+
+func (b *helm3Backend) History(namespace, name string, max int32) ([]*release.Release, error) {
+	revs, err := b.records(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	var history []*release.Release
+	for i := len(revs) - 1; i >= 0 && int32(len(history)) < max; i-- {
+		history = append(history, revs[i])
+	}
+	return history, nil
+}
+
+func (b *helm3Backend) Status(namespace, name string) (*release.Status, error) {
+	revs, err := b.records(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return revs[len(revs)-1].GetInfo().GetStatus(), nil
+}
+
+func (b *helm3Backend) Content(namespace, name string) (*release.Release, error) {
+	revs, err := b.records(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return revs[len(revs)-1], nil
+}
+
+// validateRelease rejects the settings waiting for the release resources
+// or running the release tests, which this backend doesn't do
+func (b *helm3Backend) validateRelease(hr *v1.HelmRelease) error {
+	switch {
+	case hr.Spec.Wait || hr.Spec.Atomic:
+		return fmt.Errorf("spec.wait and spec.atomic are not supported by the helm3 backend")
+	case hr.Spec.Rollback != nil && hr.Spec.Rollback.Wait:
+		return fmt.Errorf("spec.rollback.wait is not supported by the helm3 backend")
+	case testEnabled(hr):
+		return fmt.Errorf("spec.test is not supported by the helm3 backend")
+	}
+	return nil
+}
+
+// Rollback applies the manifest recorded for the target revision as a
+// new revision. The recreate option is ignored, and wait is rejected by
+// validateRelease.
+func (b *helm3Backend) Rollback(namespace, name string, opts RollbackOptions) (*release.Release, error) {
+	revs, err := b.records(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Revision < 1 || int(opts.Revision) > len(revs) || revs[opts.Revision-1].Version != opts.Revision {
+		return nil, fmt.Errorf("release %s has no revision %d", name, opts.Revision)
+	}
+	cur := revs[len(revs)-1]
+	if err := checkHooks(revs[opts.Revision-1], opts.DisableHooks, release.Hook_PRE_ROLLBACK, release.Hook_POST_ROLLBACK); err != nil {
+		return nil, err
+	}
+	rel := proto.Clone(revs[opts.Revision-1]).(*release.Release)
+	rel.Version = cur.Version + 1
+	rel.Info = &release.Info{
+		LastDeployed: timeconv.Now(),
+		Status:       &release.Status{Code: release.Status_DEPLOYED, Notes: rel.GetInfo().GetStatus().GetNotes()},
+	}
+	return b.deploy(cur, rel, opts.Force, fmt.Sprintf("Rollback to %d", opts.Revision))
+}
+
+func (b *helm3Backend) Test(namespace, name string, opts TestOptions) (*release.TestSuite, error) {
+	return nil, fmt.Errorf("release tests are not supported by the helm3 backend")
+}
+
+func (b *helm3Backend) Ping() error {
+	_, err := b.discovery.ServerVersion()
+	return err
+}
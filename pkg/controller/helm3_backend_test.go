@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+func TestHelm3ValidateRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    v1.HelmReleaseSpec
+		wantErr bool
+	}{
+		{name: "no wait"},
+		{name: "rollback without wait", spec: v1.HelmReleaseSpec{Rollback: &v1.RollbackSpec{Enable: true}}},
+		{name: "wait", spec: v1.HelmReleaseSpec{Wait: true}, wantErr: true},
+		{name: "atomic", spec: v1.HelmReleaseSpec{Atomic: true}, wantErr: true},
+		{name: "rollback wait", spec: v1.HelmReleaseSpec{Rollback: &v1.RollbackSpec{Enable: true, Wait: true}}, wantErr: true},
+		{name: "disabled tests", spec: v1.HelmReleaseSpec{Test: &v1.TestSpec{}}},
+		{name: "tests", spec: v1.HelmReleaseSpec{Test: &v1.TestSpec{Enable: true}}, wantErr: true},
+	}
+	backend := &helm3Backend{}
+	for _, tt := range tests {
+		hr := &v1.HelmRelease{Spec: tt.spec}
+		if err := backend.validateRelease(hr); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateRelease() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckHooks(t *testing.T) {
+	rel := &release.Release{Hooks: []*release.Hook{
+		{Name: "migrate", Kind: "Job", Events: []release.Hook_Event{release.Hook_PRE_INSTALL, release.Hook_PRE_UPGRADE}},
+		{Name: "test-connection", Kind: "Pod", Events: []release.Hook_Event{release.Hook_RELEASE_TEST_SUCCESS}},
+	}}
+	tests := []struct {
+		name         string
+		disableHooks bool
+		events       []release.Hook_Event
+		wantErr      bool
+	}{
+		{name: "install", events: []release.Hook_Event{release.Hook_PRE_INSTALL, release.Hook_POST_INSTALL}, wantErr: true},
+		{name: "upgrade", events: []release.Hook_Event{release.Hook_PRE_UPGRADE, release.Hook_POST_UPGRADE}, wantErr: true},
+		{name: "disabled hooks", disableHooks: true, events: []release.Hook_Event{release.Hook_PRE_INSTALL}},
+		{name: "no hooks for event", events: []release.Hook_Event{release.Hook_PRE_DELETE, release.Hook_POST_DELETE}},
+	}
+	for _, tt := range tests {
+		if err := checkHooks(rel, tt.disableHooks, tt.events...); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkHooks() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
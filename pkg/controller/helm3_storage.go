@@ -0,0 +1,439 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/timeconv"
+)
+
+// helm3Release mirrors the release records of Helm 3, so that the
+// releases of the helm3 backend can be managed with the helm 3 CLI
+type helm3Release struct {
+	Name      string                 `json:"name,omitempty"`
+	Info      *helm3Info             `json:"info,omitempty"`
+	Chart     *helm3Chart            `json:"chart,omitempty"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+	Manifest  string                 `json:"manifest,omitempty"`
+	Hooks     []*helm3Hook           `json:"hooks,omitempty"`
+	Version   int                    `json:"version,omitempty"`
+	Namespace string                 `json:"namespace,omitempty"`
+}
+
+type helm3Info struct {
+	FirstDeployed time.Time `json:"first_deployed,omitempty"`
+	LastDeployed  time.Time `json:"last_deployed,omitempty"`
+	Deleted       time.Time `json:"deleted"`
+	Description   string    `json:"description,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	Notes         string    `json:"notes,omitempty"`
+}
+
+// helm3Chart mirrors the charts of Helm 3. The JSON fields of the Helm 2
+// chart metadata are those of Helm 3, plus a few it ignores.
+type helm3Chart struct {
+	Metadata  *chart.Metadata        `json:"metadata"`
+	Templates []*helm3File           `json:"templates"`
+	Values    map[string]interface{} `json:"values"`
+	Files     []*helm3File           `json:"files"`
+}
+
+type helm3File struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+type helm3Hook struct {
+	Name           string   `json:"name,omitempty"`
+	Kind           string   `json:"kind,omitempty"`
+	Path           string   `json:"path,omitempty"`
+	Manifest       string   `json:"manifest,omitempty"`
+	Events         []string `json:"events,omitempty"`
+	Weight         int      `json:"weight,omitempty"`
+	DeletePolicies []string `json:"delete_policies,omitempty"`
+}
+
+const (
+	helm3SecretType   = "helm.sh/release.v1"
+	helm3SecretPrefix = "sh.helm.release.v1."
+
+	// Tiller keeps its records as ConfigMaps labelled this way in its
+	// namespace
+	tillerOwnerLabel = "OWNER=TILLER"
+	// tillerImportedLabel marks the Tiller records already imported, so
+	// that purged releases aren't imported again
+	tillerImportedLabel = "helm.bitnami.com/imported"
+)
+
+var helm3Statuses = map[release.Status_Code]string{
+	release.Status_UNKNOWN:          "unknown",
+	release.Status_DEPLOYED:         "deployed",
+	release.Status_DELETED:          "uninstalled",
+	release.Status_SUPERSEDED:       "superseded",
+	release.Status_FAILED:           "failed",
+	release.Status_DELETING:         "uninstalling",
+	release.Status_PENDING_INSTALL:  "pending-install",
+	release.Status_PENDING_UPGRADE:  "pending-upgrade",
+	release.Status_PENDING_ROLLBACK: "pending-rollback",
+}
+
+var helm3HookEvents = map[release.Hook_Event]string{
+	release.Hook_PRE_INSTALL:          "pre-install",
+	release.Hook_POST_INSTALL:         "post-install",
+	release.Hook_PRE_DELETE:           "pre-delete",
+	release.Hook_POST_DELETE:          "post-delete",
+	release.Hook_PRE_UPGRADE:          "pre-upgrade",
+	release.Hook_POST_UPGRADE:         "post-upgrade",
+	release.Hook_PRE_ROLLBACK:         "pre-rollback",
+	release.Hook_POST_ROLLBACK:        "post-rollback",
+	release.Hook_RELEASE_TEST_SUCCESS: "test",
+	release.Hook_RELEASE_TEST_FAILURE: "test-failure",
+}
+
+var helm3HookDeletePolicies = map[release.Hook_DeletePolicy]string{
+	release.Hook_SUCCEEDED:            "hook-succeeded",
+	release.Hook_FAILED:               "hook-failed",
+	release.Hook_BEFORE_HOOK_CREATION: "before-hook-creation",
+}
+
+func parseValuesMap(raw string) (map[string]interface{}, error) {
+	values, err := chartutil.ReadValues([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func toHelm3Release(rel *release.Release) (*helm3Release, error) {
+	config, err := parseValuesMap(rel.GetConfig().GetRaw())
+	if err != nil {
+		return nil, err
+	}
+	h := &helm3Release{
+		Name:      rel.Name,
+		Config:    config,
+		Manifest:  rel.Manifest,
+		Version:   int(rel.Version),
+		Namespace: rel.Namespace,
+	}
+
+	info := rel.GetInfo()
+	h.Info = &helm3Info{
+		Description: info.GetDescription(),
+		Status:      helm3Statuses[info.GetStatus().GetCode()],
+		Notes:       info.GetStatus().GetNotes(),
+	}
+	if info.GetFirstDeployed() != nil {
+		h.Info.FirstDeployed = timeconv.Time(info.FirstDeployed)
+	}
+	if info.GetLastDeployed() != nil {
+		h.Info.LastDeployed = timeconv.Time(info.LastDeployed)
+	}
+	if info.GetDeleted() != nil {
+		h.Info.Deleted = timeconv.Time(info.Deleted)
+	}
+
+	if ch := rel.GetChart(); ch != nil {
+		values, err := parseValuesMap(ch.GetValues().GetRaw())
+		if err != nil {
+			return nil, err
+		}
+		h.Chart = &helm3Chart{Metadata: ch.Metadata, Values: values}
+		for _, t := range ch.Templates {
+			h.Chart.Templates = append(h.Chart.Templates, &helm3File{Name: t.Name, Data: t.Data})
+		}
+		for _, f := range ch.Files {
+			h.Chart.Files = append(h.Chart.Files, &helm3File{Name: f.TypeUrl, Data: f.Value})
+		}
+	}
+
+	for _, hook := range rel.Hooks {
+		h3 := &helm3Hook{
+			Name:     hook.Name,
+			Kind:     hook.Kind,
+			Path:     hook.Path,
+			Manifest: hook.Manifest,
+			Weight:   int(hook.Weight),
+		}
+		for _, e := range hook.Events {
+			h3.Events = append(h3.Events, helm3HookEvents[e])
+		}
+		for _, p := range hook.DeletePolicies {
+			h3.DeletePolicies = append(h3.DeletePolicies, helm3HookDeletePolicies[p])
+		}
+		h.Hooks = append(h.Hooks, h3)
+	}
+	return h, nil
+}
+
+func fromHelm3Release(h *helm3Release) (*release.Release, error) {
+	config, err := yaml.Marshal(h.Config)
+	if err != nil {
+		return nil, err
+	}
+	rel := &release.Release{
+		Name:      h.Name,
+		Config:    &chart.Config{Raw: string(config)},
+		Manifest:  h.Manifest,
+		Version:   int32(h.Version),
+		Namespace: h.Namespace,
+		Info:      &release.Info{Status: &release.Status{}},
+	}
+
+	if h.Info != nil {
+		for code, s := range helm3Statuses {
+			if s == h.Info.Status {
+				rel.Info.Status.Code = code
+			}
+		}
+		rel.Info.Status.Notes = h.Info.Notes
+		rel.Info.Description = h.Info.Description
+		if !h.Info.FirstDeployed.IsZero() {
+			rel.Info.FirstDeployed = timeconv.Timestamp(h.Info.FirstDeployed)
+		}
+		if !h.Info.LastDeployed.IsZero() {
+			rel.Info.LastDeployed = timeconv.Timestamp(h.Info.LastDeployed)
+		}
+		if !h.Info.Deleted.IsZero() {
+			rel.Info.Deleted = timeconv.Timestamp(h.Info.Deleted)
+		}
+	}
+
+	if h.Chart != nil {
+		values, err := yaml.Marshal(h.Chart.Values)
+		if err != nil {
+			return nil, err
+		}
+		rel.Chart = &chart.Chart{
+			Metadata: h.Chart.Metadata,
+			Values:   &chart.Config{Raw: string(values)},
+		}
+		for _, t := range h.Chart.Templates {
+			rel.Chart.Templates = append(rel.Chart.Templates, &chart.Template{Name: t.Name, Data: t.Data})
+		}
+		for _, f := range h.Chart.Files {
+			rel.Chart.Files = append(rel.Chart.Files, &any.Any{TypeUrl: f.Name, Value: f.Data})
+		}
+	}
+
+	for _, h3 := range h.Hooks {
+		hook := &release.Hook{
+			Name:     h3.Name,
+			Kind:     h3.Kind,
+			Path:     h3.Path,
+			Manifest: h3.Manifest,
+			Weight:   int32(h3.Weight),
+		}
+		for _, event := range h3.Events {
+			for e, s := range helm3HookEvents {
+				if event == s {
+					hook.Events = append(hook.Events, e)
+				}
+			}
+		}
+		for _, policy := range h3.DeletePolicies {
+			for p, s := range helm3HookDeletePolicies {
+				if policy == s {
+					hook.DeletePolicies = append(hook.DeletePolicies, p)
+				}
+			}
+		}
+		rel.Hooks = append(rel.Hooks, hook)
+	}
+	return rel, nil
+}
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// decodeRecord reverses the base64 and optional gzip encoding both Helm
+// 2 and Helm 3 apply to their release records
+func decodeRecord(data string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return b, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func encodeHelm3Record(rel *release.Release) (string, error) {
+	h, err := toHelm3Release(rel)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(b); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeHelm3Record(data string) (*release.Release, error) {
+	b, err := decodeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	var h helm3Release
+	if err := json.Unmarshal(b, &h); err != nil {
+		return nil, err
+	}
+	return fromHelm3Release(&h)
+}
+
+func helm3SecretName(rel *release.Release) string {
+	return fmt.Sprintf("%s%s.v%d", helm3SecretPrefix, rel.Name, rel.Version)
+}
+
+// records returns the revisions of the release name in namespace, oldest
+// first. Releases only known to Tiller are imported on the way.
+func (b *helm3Backend) records(namespace, name string) ([]*release.Release, error) {
+	list, err := b.kubeClient.CoreV1().Secrets(namespace).List(metav1.ListOptions{
+		LabelSelector: "owner=helm,name=" + name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var revs []*release.Release
+	for _, secret := range list.Items {
+		if secret.Type != helm3SecretType {
+			continue
+		}
+		rel, err := decodeHelm3Record(string(secret.Data["release"]))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode release record %s/%s: %v", secret.Namespace, secret.Name, err)
+		}
+		revs = append(revs, rel)
+	}
+	if len(revs) == 0 {
+		if revs, err = b.importTillerRecords(namespace, name); err != nil {
+			return nil, err
+		}
+	}
+	if len(revs) == 0 {
+		return nil, ErrReleaseNotFound
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Version < revs[j].Version })
+	return revs, nil
+}
+
+// storeRecord creates or updates the record of a revision of a release
+func (b *helm3Backend) storeRecord(rel *release.Release) error {
+	data, err := encodeHelm3Record(rel)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      helm3SecretName(rel),
+			Namespace: rel.Namespace,
+			Labels: map[string]string{
+				"name":    rel.Name,
+				"owner":   "helm",
+				"status":  helm3Statuses[rel.GetInfo().GetStatus().GetCode()],
+				"version": strconv.Itoa(int(rel.Version)),
+			},
+		},
+		Type: helm3SecretType,
+		Data: map[string][]byte{"release": []byte(data)},
+	}
+	secrets := b.kubeClient.CoreV1().Secrets(rel.Namespace)
+	_, err = secrets.Create(secret)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(secret)
+	}
+	return err
+}
+
+func (b *helm3Backend) deleteRecord(rel *release.Release) error {
+	err := b.kubeClient.CoreV1().Secrets(rel.Namespace).Delete(helm3SecretName(rel), &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// importTillerRecords copies the records Tiller kept of the release name
+// in namespace as Helm 3 records, so that releases installed through
+// Tiller carry on with the helm3 backend. The Tiller records are left in
+// place, labelled as imported so that they aren't imported again once
+// the release is purged.
+func (b *helm3Backend) importTillerRecords(namespace, name string) ([]*release.Release, error) {
+	configMaps := b.kubeClient.CoreV1().ConfigMaps(b.tillerNamespace)
+	list, err := configMaps.List(metav1.ListOptions{
+		LabelSelector: tillerOwnerLabel + ",NAME=" + name + ",!" + tillerImportedLabel,
+	})
+	if err != nil {
+		if apierrors.IsForbidden(err) {
+			glog.Warningf("Unable to look for Tiller records of release %s: %v", name, err)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var revs []*release.Release
+	var imported []corev1.ConfigMap
+	for _, cm := range list.Items {
+		data, err := decodeRecord(cm.Data["release"])
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode Tiller record %s/%s: %v", cm.Namespace, cm.Name, err)
+		}
+		rel := &release.Release{}
+		if err := proto.Unmarshal(data, rel); err != nil {
+			return nil, fmt.Errorf("unable to decode Tiller record %s/%s: %v", cm.Namespace, cm.Name, err)
+		}
+		if rel.Namespace != namespace {
+			continue
+		}
+		revs = append(revs, rel)
+		imported = append(imported, cm)
+	}
+	for _, rel := range revs {
+		glog.Infof("Importing revision %d of release %s from Tiller", rel.Version, name)
+		if err := b.storeRecord(rel); err != nil {
+			return nil, err
+		}
+	}
+	for i := range imported {
+		cm := &imported[i]
+		cm.Labels[tillerImportedLabel] = "true"
+		if _, err := configMaps.Update(cm); err != nil {
+			return nil, fmt.Errorf("unable to label Tiller record %s/%s as imported: %v", cm.Namespace, cm.Name, err)
+		}
+	}
+	return revs, nil
+}
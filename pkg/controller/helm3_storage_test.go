@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/timeconv"
+)
+
+func newTestRecord(version int32, code release.Status_Code) *release.Release {
+	return &release.Release{
+		Name:      testRelease,
+		Namespace: testNamespace,
+		Version:   version,
+		Manifest:  "\n---\n# Source: mychart/templates/cm.yaml\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n",
+		Config:    &chart.Config{Raw: "replicas: 2\n"},
+		Chart: &chart.Chart{
+			Metadata:  &chart.Metadata{Name: "mychart", Version: "1.0.0", ApiVersion: "v1"},
+			Templates: []*chart.Template{{Name: "templates/cm.yaml", Data: []byte("kind: ConfigMap")}},
+			Values:    &chart.Config{Raw: "replicas: 1\n"},
+		},
+		Hooks: []*release.Hook{{
+			Name:           "test",
+			Kind:           "Pod",
+			Events:         []release.Hook_Event{release.Hook_RELEASE_TEST_SUCCESS},
+			DeletePolicies: []release.Hook_DeletePolicy{release.Hook_SUCCEEDED},
+		}},
+		Info: &release.Info{
+			FirstDeployed: timeconv.Now(),
+			LastDeployed:  timeconv.Now(),
+			Description:   "Install complete",
+			Status:        &release.Status{Code: code, Notes: "notes"},
+		},
+	}
+}
+
+func TestHelm3RecordRoundTrip(t *testing.T) {
+	backend := &helm3Backend{kubeClient: kubefake.NewSimpleClientset()}
+	for _, rel := range []*release.Release{
+		newTestRecord(1, release.Status_SUPERSEDED),
+		newTestRecord(2, release.Status_DEPLOYED),
+	} {
+		if err := backend.storeRecord(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secret, err := backend.kubeClient.CoreV1().Secrets(testNamespace).Get("sh.helm.release.v1."+testRelease+".v2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != helm3SecretType || secret.Labels["status"] != "deployed" || secret.Labels["version"] != "2" {
+		t.Errorf("unexpected record secret: type %q, labels %v", secret.Type, secret.Labels)
+	}
+
+	revs, err := backend.records(testNamespace, testRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revs))
+	}
+	got, want := revs[1], newTestRecord(2, release.Status_DEPLOYED)
+	if got.Version != 2 || got.Info.Status.Code != release.Status_DEPLOYED {
+		t.Errorf("got revision %d with status %s, want 2 DEPLOYED", got.Version, got.Info.Status.Code)
+	}
+	if got.Manifest != want.Manifest || got.Info.Status.Notes != "notes" || got.Info.Description != want.Info.Description {
+		t.Errorf("release info was not preserved: %v", got)
+	}
+	if got.Config.Raw != want.Config.Raw || got.Chart.Values.Raw != want.Chart.Values.Raw {
+		t.Errorf("got values %q and chart values %q, want %q and %q", got.Config.Raw, got.Chart.Values.Raw, want.Config.Raw, want.Chart.Values.Raw)
+	}
+	if !proto.Equal(got.Chart.Metadata, want.Chart.Metadata) || len(got.Chart.Templates) != 1 {
+		t.Errorf("chart was not preserved: %v", got.Chart)
+	}
+	if !proto.Equal(got.Hooks[0], want.Hooks[0]) {
+		t.Errorf("got hook %v, want %v", got.Hooks[0], want.Hooks[0])
+	}
+
+	if _, err := backend.records(testNamespace, "other"); err != ErrReleaseNotFound {
+		t.Errorf("records of unknown release: got error %v, want %v", err, ErrReleaseNotFound)
+	}
+}
+
+func TestHelm3ImportTillerRecords(t *testing.T) {
+	rel := newTestRecord(1, release.Status_DEPLOYED)
+	data, err := proto.Marshal(rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testRelease + ".v1",
+			Namespace: "kube-system",
+			Labels:    map[string]string{"OWNER": "TILLER", "NAME": testRelease, "VERSION": "1", "STATUS": "DEPLOYED"},
+		},
+		Data: map[string]string{"release": base64.StdEncoding.EncodeToString(buf.Bytes())},
+	}
+
+	backend := &helm3Backend{
+		kubeClient:      kubefake.NewSimpleClientset(cm),
+		tillerNamespace: "kube-system",
+	}
+	if _, err := backend.History("other", testRelease, 10); err != ErrReleaseNotFound {
+		t.Errorf("history in another namespace: got error %v, want %v", err, ErrReleaseNotFound)
+	}
+	history, err := backend.History(testNamespace, testRelease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Version != 1 || history[0].Manifest != rel.Manifest {
+		t.Fatalf("unexpected history after import: %v", history)
+	}
+	if _, err := backend.kubeClient.CoreV1().Secrets(testNamespace).Get("sh.helm.release.v1."+testRelease+".v1", metav1.GetOptions{}); err != nil {
+		t.Errorf("Tiller record was not imported: %v", err)
+	}
+
+	// A purged release isn't imported again
+	if err := backend.deleteRecord(history[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.History(testNamespace, testRelease, 10); err != ErrReleaseNotFound {
+		t.Errorf("history after purge: got error %v, want %v", err, ErrReleaseNotFound)
+	}
+}
@@ -1,9 +1,9 @@
 package controller
 
 import (
-	"reflect"
-
 	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/client-go/tools/cache"
 
 	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
@@ -11,8 +11,22 @@ import (
 
 func (c *Controller) onAddFunc(obj interface{}) {
 	hr := obj.(*v1.HelmRelease)
-	switch hr.Status.Phase {
-	case v1.HelmRealeasePhaseUnknown:
+	if hr.Status.Revision > 0 {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			c.healthQueue.Add(key)
+		}
+	}
+	switch {
+	case hr.Status.Phase == v1.HelmRealeasePhaseUnknown, hr.Status.Phase == v1.HelmRealeasePhaseWaiting:
+	case hr.Status.Phase == v1.HelmRealeasePhaseDeploying:
+		// Interrupted while waiting for the release resources
+	case hr.DeletionTimestamp != nil, !hasFinalizer(hr), hr.Status.ObservedGeneration != hr.Generation:
+		// Deleted or changed while the controller was down, or created
+		// before finalizers were in use
+	case canRetryUpgrade(hr):
+		// Upgrade retries pending after a rollback
+	case hr.Status.Phase == v1.HelmRealeasePhaseReady && c.driftCheckEnabled(hr):
+		// Drift checks are scheduled by the reconciles
 	default:
 		glog.Infof("HelmRelease %s/%s is not new, skipping (phase=%q)", hr.Namespace, hr.Name, hr.Status.Phase)
 		return
@@ -26,15 +40,34 @@ func (c *Controller) onAddFunc(obj interface{}) {
 func (c *Controller) onUpdateFunc(oldObj, newObj interface{}) {
 	oldhr := oldObj.(*v1.HelmRelease)
 	newhr := newObj.(*v1.HelmRelease)
-	if oldhr.ResourceVersion == newhr.ResourceVersion {
+	// Keep retrying deletions on every resync
+	if newhr.DeletionTimestamp != nil {
+		key, err := cache.MetaNamespaceKeyFunc(newObj)
+		if err == nil {
+			c.queue.Add(key)
+		}
 		return
 	}
-	// TODO: deal with failure?
-	if newhr.Status.Phase == v1.HelmRealeasePhaseFailed {
-		glog.Infof("Skipping helmrelease %s (phase=%q)", newhr.Name, newhr.Status.Phase)
+	if oldhr.ResourceVersion == newhr.ResourceVersion {
 		return
 	}
-	if reflect.DeepEqual(newhr.Spec, oldhr.Spec) && newhr.Status.Phase == v1.HelmRealeasePhaseReady {
+	// Paused releases are skipped on every reconcile, the event is only
+	// recorded once
+	if newhr.Spec.Paused && !oldhr.Spec.Paused {
+		c.recorder.Event(newhr, corev1.EventTypeNormal, reasonPaused, "HelmRelease is paused")
+	}
+	if releaseReady(newhr) && !releaseReady(oldhr) {
+		c.enqueueDependents(newhr)
+	}
+	// Installs, upgrades and rollbacks change the resources to check
+	if newhr.Status.Revision != oldhr.Status.Revision {
+		if key, err := cache.MetaNamespaceKeyFunc(newObj); err == nil {
+			c.healthQueue.Add(key)
+		}
+	}
+	// Status and metadata-only changes, such as attaching the finalizer,
+	// don't bump the generation
+	if newhr.Generation == oldhr.Generation {
 		return
 	}
 	key, err := cache.MetaNamespaceKeyFunc(newObj)
@@ -47,5 +80,57 @@ func (c *Controller) onDeleteFunc(obj interface{}) {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err == nil {
 		c.queue.Add(key)
+		c.healthQueue.Add(key)
+	}
+}
+
+// valuesSourceEventHandler requeues the HelmReleases referencing a
+// ConfigMap or Secret of the given kind in spec.valuesFrom whenever it
+// changes.
+func (c *Controller) valuesSourceEventHandler(kind string) cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		meta, err := apimeta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		hrs, err := c.informer.GetIndexer().ByIndex(valuesFromIndex, valuesFromIndexKey(kind, meta.GetNamespace(), meta.GetName()))
+		if err != nil {
+			glog.Errorf("Error looking up HelmReleases referencing %s %s/%s: %v", kind, meta.GetNamespace(), meta.GetName(), err)
+			return
+		}
+		for _, hr := range hrs {
+			key, err := cache.MetaNamespaceKeyFunc(hr)
+			if err == nil {
+				glog.Infof("%s %s/%s changed, requeueing HelmRelease %s", kind, meta.GetNamespace(), meta.GetName(), key)
+				c.queue.Add(key)
+			}
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			// Objects listed on startup are not changes
+			if !c.HasSynced() {
+				return
+			}
+			enqueue(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, err := apimeta.Accessor(oldObj)
+			if err != nil {
+				return
+			}
+			newMeta, err := apimeta.Accessor(newObj)
+			if err != nil {
+				return
+			}
+			if oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				return
+			}
+			enqueue(newObj)
+		},
+		DeleteFunc: enqueue,
 	}
 }
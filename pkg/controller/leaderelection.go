@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"os"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// RunWithLeaderElection runs the controller once this replica is elected
+// leader, so that several replicas can be deployed with only one of them
+// acting on HelmReleases at a time. It exits the process when leadership
+// is lost.
+func (c *Controller) RunWithLeaderElection(stopCh <-chan struct{}) {
+	cfg := c.config.LeaderElection
+	// The pod name is unique among replicas
+	id, err := os.Hostname()
+	if err != nil {
+		glog.Fatalf("Unable to get hostname: %v", err)
+	}
+
+	switch cfg.ResourceLock {
+	case resourcelock.ConfigMapsResourceLock, resourcelock.EndpointsResourceLock:
+	default:
+		glog.Fatalf("Unsupported leader election resource lock %q, must be one of %q or %q",
+			cfg.ResourceLock, resourcelock.ConfigMapsResourceLock, resourcelock.EndpointsResourceLock)
+	}
+	lock, err := resourcelock.New(
+		cfg.ResourceLock,
+		cfg.Namespace,
+		cfg.Name,
+		c.kubeClientset.CoreV1(),
+		resourcelock.ResourceLockConfig{
+			Identity:      id,
+			EventRecorder: c.recorder,
+		},
+	)
+	if err != nil {
+		glog.Fatalf("Unable to create leader election lock: %v", err)
+	}
+
+	glog.Infof("Waiting to acquire leader lease %s/%s as %s", cfg.Namespace, cfg.Name, id)
+	leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: cfg.LeaseDuration,
+		RenewDeadline: cfg.RenewDeadline,
+		RetryPeriod:   cfg.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderStopCh <-chan struct{}) {
+				glog.Infof("Became leader, starting %s", controllerName)
+				c.Run(mergeStopChannels(stopCh, leaderStopCh))
+			},
+			OnStoppedLeading: func() {
+				glog.Fatalf("Lost leader lease %s/%s", cfg.Namespace, cfg.Name)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					glog.Infof("Current leader is %s", identity)
+				}
+			},
+		},
+	})
+}
+
+// mergeStopChannels returns a channel closed when either a or b is closed
+func mergeStopChannels(a, b <-chan struct{}) <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		defer close(ch)
+		select {
+		case <-a:
+		case <-b:
+		}
+	}()
+	return ch
+}
+
+// defaultLeaderElectNamespace is the namespace the controller runs in, as
+// exposed by the downward API, falling back to kube-system
+func defaultLeaderElectNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return "kube-system"
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	rls "k8s.io/helm/pkg/proto/hapi/services"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const metricsNamespace = "helm_crd"
+
+var (
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_total",
+		Help:      "Number of HelmRelease reconciles by outcome.",
+	}, []string{"outcome"})
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of HelmRelease reconciles by outcome.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"outcome"})
+	chartDownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "chart_download_duration_seconds",
+		Help:      "Duration of chart downloads by repository.",
+	}, []string{"repo"})
+	chartDownloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "chart_download_bytes_total",
+		Help:      "Bytes of charts downloaded by repository.",
+	}, []string{"repo"})
+	chartCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "chart_cache_requests_total",
+		Help:      "Number of chart cache lookups by result, hit or miss.",
+	}, []string{"result"})
+	repoIndexRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "repo_index_refresh_total",
+		Help:      "Number of repository index refreshes by repository and result, updated, unchanged or error.",
+	}, []string{"repo", "result"})
+	repoIndexLastRefresh = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "repo_index_last_refresh_timestamp_seconds",
+		Help:      "Time of the last successful refresh of each repository index.",
+	}, []string{"repo"})
+	tillerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "tiller_request_duration_seconds",
+		Help:      "Latency of Tiller gRPC calls by method and outcome.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300},
+	}, []string{"method", "outcome"})
+	tillerUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tiller_up",
+		Help:      "Whether the last ping to Tiller succeeded.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		reconcileTotal,
+		reconcileDuration,
+		chartDownloadDuration,
+		chartDownloadBytes,
+		chartCacheRequests,
+		repoIndexRefreshes,
+		repoIndexLastRefresh,
+		tillerRequestDuration,
+		tillerUp,
+	)
+	workqueue.SetProvider(workqueueMetricsProvider{})
+}
+
+// ServeMetrics serves the Prometheus metrics on /metrics at addr, along
+// with the state of the repository index cache on /repositories. It
+// blocks until the server fails.
+func (c *Controller) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, c.registry}, promhttp.HandlerOpts{}))
+	mux.Handle("/repositories", c.indexes)
+	glog.Infof("Serving metrics on %s/metrics", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func observeReconcile(start time.Time, err error) {
+	reconcileTotal.WithLabelValues(outcome(err)).Inc()
+	reconcileDuration.WithLabelValues(outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// releasePhaseCollector reports the number of HelmReleases per phase,
+// counted from the informer cache at scrape time
+type releasePhaseCollector struct {
+	informer cache.SharedIndexInformer
+}
+
+var releasePhaseDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metricsNamespace, "", "helmreleases"),
+	"Number of HelmReleases per phase.",
+	[]string{"phase"}, nil,
+)
+
+func (r releasePhaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- releasePhaseDesc
+}
+
+func (r releasePhaseCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[v1.HelmRealeasePhase]int{
+		v1.HelmRealeasePhaseUnknown:    0,
+		v1.HelmRealeasePhaseReady:      0,
+		v1.HelmRealeasePhaseFailed:     0,
+		v1.HelmRealeasePhaseRolledBack: 0,
+		v1.HelmRealeasePhaseWaiting:    0,
+		v1.HelmRealeasePhaseDeploying:  0,
+	}
+	for _, obj := range r.informer.GetStore().List() {
+		counts[obj.(*v1.HelmRelease).Status.Phase]++
+	}
+	for phase, n := range counts {
+		label := string(phase)
+		if phase == v1.HelmRealeasePhaseUnknown {
+			label = "Unknown"
+		}
+		ch <- prometheus.MustNewConstMetric(releasePhaseDesc, prometheus.GaugeValue, float64(n), label)
+	}
+}
+
+// instrumentedHelmClient records the latency of the Tiller calls made by
+// the controller
+type instrumentedHelmClient struct {
+	helm.Interface
+}
+
+func observeTiller(method string, start time.Time, err error) {
+	tillerRequestDuration.WithLabelValues(method, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+func (h instrumentedHelmClient) InstallReleaseFromChart(chart *chart.Chart, ns string, opts ...helm.InstallOption) (res *rls.InstallReleaseResponse, err error) {
+	defer func(start time.Time) { observeTiller("InstallRelease", start, err) }(time.Now())
+	return h.Interface.InstallReleaseFromChart(chart, ns, opts...)
+}
+
+func (h instrumentedHelmClient) UpdateReleaseFromChart(rlsName string, chart *chart.Chart, opts ...helm.UpdateOption) (res *rls.UpdateReleaseResponse, err error) {
+	defer func(start time.Time) { observeTiller("UpdateRelease", start, err) }(time.Now())
+	return h.Interface.UpdateReleaseFromChart(rlsName, chart, opts...)
+}
+
+func (h instrumentedHelmClient) DeleteRelease(rlsName string, opts ...helm.DeleteOption) (res *rls.UninstallReleaseResponse, err error) {
+	defer func(start time.Time) { observeTiller("UninstallRelease", start, err) }(time.Now())
+	return h.Interface.DeleteRelease(rlsName, opts...)
+}
+
+func (h instrumentedHelmClient) RollbackRelease(rlsName string, opts ...helm.RollbackOption) (res *rls.RollbackReleaseResponse, err error) {
+	defer func(start time.Time) { observeTiller("RollbackRelease", start, err) }(time.Now())
+	return h.Interface.RollbackRelease(rlsName, opts...)
+}
+
+func (h instrumentedHelmClient) ReleaseStatus(rlsName string, opts ...helm.StatusOption) (res *rls.GetReleaseStatusResponse, err error) {
+	defer func(start time.Time) { observeTiller("GetReleaseStatus", start, err) }(time.Now())
+	return h.Interface.ReleaseStatus(rlsName, opts...)
+}
+
+func (h instrumentedHelmClient) ReleaseContent(rlsName string, opts ...helm.ContentOption) (res *rls.GetReleaseContentResponse, err error) {
+	defer func(start time.Time) { observeTiller("GetReleaseContent", start, err) }(time.Now())
+	return h.Interface.ReleaseContent(rlsName, opts...)
+}
+
+func (h instrumentedHelmClient) ReleaseHistory(rlsName string, opts ...helm.HistoryOption) (res *rls.GetHistoryResponse, err error) {
+	defer func(start time.Time) { observeTiller("GetHistory", start, err) }(time.Now())
+	return h.Interface.ReleaseHistory(rlsName, opts...)
+}
+
+func (h instrumentedHelmClient) PingTiller() (err error) {
+	defer func(start time.Time) { observeTiller("Ping", start, err) }(time.Now())
+	err = h.Interface.PingTiller()
+	if err != nil {
+		tillerUp.Set(0)
+	} else {
+		tillerUp.Set(1)
+	}
+	return err
+}
+
+// workqueueMetricsProvider exposes the client-go workqueue metrics
+type workqueueMetricsProvider struct{}
+
+func (workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return registerWorkqueueMetric(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: name,
+		Name:      "workqueue_depth",
+		Help:      "Current depth of the workqueue.",
+	})).(prometheus.Gauge)
+}
+
+func (workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return registerWorkqueueMetric(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: name,
+		Name:      "workqueue_adds_total",
+		Help:      "Total number of adds handled by the workqueue.",
+	})).(prometheus.Counter)
+}
+
+func (workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.SummaryMetric {
+	return registerWorkqueueMetric(prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace: metricsNamespace,
+		Subsystem: name,
+		Name:      "workqueue_queue_latency_microseconds",
+		Help:      "How long an item stays in the workqueue before being processed.",
+	})).(prometheus.Summary)
+}
+
+func (workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.SummaryMetric {
+	return registerWorkqueueMetric(prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace: metricsNamespace,
+		Subsystem: name,
+		Name:      "workqueue_work_duration_microseconds",
+		Help:      "How long processing an item from the workqueue takes.",
+	})).(prometheus.Summary)
+}
+
+func (workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return registerWorkqueueMetric(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: name,
+		Name:      "workqueue_retries_total",
+		Help:      "Total number of retries handled by the workqueue.",
+	})).(prometheus.Counter)
+}
+
+func registerWorkqueueMetric(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		glog.Warningf("Unable to register workqueue metric: %v", err)
+	}
+	return c
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+func TestReleasePhaseCollector(t *testing.T) {
+
+	// Each controller reports its own releases
+	for _, phase := range []v1.HelmRealeasePhase{v1.HelmRealeasePhaseReady, v1.HelmRealeasePhaseFailed} {
+		hr := newTestRelease("")
+		hr.Status.Phase = phase
+		c, _ := newTestController(t, NewFakeBackend(), hr)
+
+		families, err := c.registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, total := map[string]float64{}, 0.0
+		for _, family := range families {
+			if family.GetName() != "helm_crd_helmreleases" {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				got[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+				total += m.GetGauge().GetValue()
+			}
+		}
+		if got[string(phase)] != 1 || total != 1 {
+			t.Errorf("helmreleases = %v, want 1 %s release", got, phase)
+		}
+	}
+}
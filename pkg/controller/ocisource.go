@@ -0,0 +1,392 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociChartMediaType    = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	// ociLegacyChartMediaType is the chart layer type of Helm 3 releases
+	// before 3.0.0
+	ociLegacyChartMediaType = "application/tar+gzip"
+
+	// ociManifestMaxBytes bounds the size of manifests, which are read
+	// in memory
+	ociManifestMaxBytes = 4 << 20
+)
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// ociReference is a parsed oci:// chart URL
+type ociReference struct {
+	host       string
+	repository string
+	tag        string
+	digest     string
+}
+
+func parseOCIReference(u string) (*ociReference, error) {
+	if !strings.HasPrefix(u, "oci://") {
+		return nil, fmt.Errorf("OCI chart URL %q must start with oci://", u)
+	}
+	rest := strings.TrimPrefix(u, "oci://")
+	slash := strings.Index(rest, "/")
+	if slash <= 0 {
+		return nil, fmt.Errorf("OCI chart URL %q has no repository", u)
+	}
+	ref := &ociReference{host: rest[:slash], repository: rest[slash+1:], tag: "latest"}
+	if at := strings.Index(ref.repository, "@"); at >= 0 {
+		ref.repository, ref.digest, ref.tag = ref.repository[:at], ref.repository[at+1:], ""
+	} else if colon := strings.LastIndex(ref.repository, ":"); colon >= 0 {
+		ref.repository, ref.tag = ref.repository[:colon], ref.repository[colon+1:]
+	}
+	if ref.repository == "" {
+		return nil, fmt.Errorf("OCI chart URL %q has no repository", u)
+	}
+	return ref, nil
+}
+
+// ociClient pulls artifacts from a registry implementing the OCI
+// distribution API. Bearer tokens are requested when the registry asks
+// for them.
+type ociClient struct {
+	client   *http.Client
+	baseURL  string
+	username string
+	password string
+	// authorization is the Authorization header answering the last
+	// challenge of the registry
+	authorization string
+}
+
+// ociClient returns a client for the registry of ref, with the
+// credentials found for it in the pull secrets of the source
+func (c *Controller) ociClient(ns string, oci *v1.OCISource, ref *ociReference) (*ociClient, error) {
+	client := &ociClient{client: defaultHTTPClient, baseURL: "https://" + ref.host}
+	if oci.PlainHTTP {
+		client.baseURL = "http://" + ref.host
+	}
+	for _, secretRef := range oci.PullSecrets {
+		obj, exists, err := c.secretInformer.GetIndexer().GetByKey(ns + "/" + secretRef.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("pull secret %s/%s not found", ns, secretRef.Name)
+		}
+		data, ok := obj.(*corev1.Secret).Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			return nil, fmt.Errorf("pull secret %s/%s has no %q key", ns, secretRef.Name, corev1.DockerConfigJsonKey)
+		}
+		username, password, found, err := dockerConfigCredentials(data, ref.host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pull secret %s/%s: %v", ns, secretRef.Name, err)
+		}
+		if found {
+			client.username, client.password = username, password
+			break
+		}
+	}
+	return client, nil
+}
+
+// dockerConfigCredentials returns the credentials of host in a
+// .dockerconfigjson file
+func dockerConfigCredentials(data []byte, host string) (username, password string, found bool, err error) {
+	var config struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", false, err
+	}
+	for server, auth := range config.Auths {
+		// Servers may be written as URLs
+		server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+		if i := strings.Index(server, "/"); i >= 0 {
+			server = server[:i]
+		}
+		if server != host {
+			continue
+		}
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, true, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", false, err
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", false, fmt.Errorf("auth of %s is not user:password", host)
+		}
+		return parts[0], parts[1], true, nil
+	}
+	return "", "", false, nil
+}
+
+// get fetches path from the registry, answering its authentication
+// challenge if any. Responses other than 200 are returned as errors.
+func (o *ociClient) get(path, accept, scope string) (*http.Response, error) {
+	return o.do("GET", path, accept, scope)
+}
+
+// do sends a method request for path to the registry, answering its
+// authentication challenge if any. Responses other than 200 are returned
+// as errors.
+func (o *ociClient) do(method, path, accept, scope string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, o.baseURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if o.authorization != "" {
+			req.Header.Set("Authorization", o.authorization)
+		}
+		resp, err := o.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return nil, fmt.Errorf("failed to fetch %s: %s", o.baseURL+path, resp.Status)
+		}
+		if err := o.authorize(resp.Header.Get("WWW-Authenticate"), scope); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authorize answers the challenge of the registry, requesting a bearer
+// token from its token service if needed
+func (o *ociClient) authorize(challenge, scope string) error {
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte(o.username+":"+o.password))
+	switch {
+	case strings.HasPrefix(challenge, "Basic"):
+		if o.username == "" {
+			return fmt.Errorf("registry %s requires credentials", o.baseURL)
+		}
+		o.authorization = basic
+		return nil
+	case !strings.HasPrefix(challenge, "Bearer"):
+		return fmt.Errorf("unsupported authentication challenge %q from %s", challenge, o.baseURL)
+	}
+
+	params := map[string]string{}
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["realm"] == "" {
+		return fmt.Errorf("authentication challenge of %s has no realm", o.baseURL)
+	}
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if s := params["scope"]; s != "" {
+		scope = s
+	}
+	query.Set("scope", scope)
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if o.username != "" {
+		req.Header.Set("Authorization", basic)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get a token for %s: %s", o.baseURL, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("invalid token response for %s: %v", o.baseURL, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	o.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// ociManifest is the part of an OCI image manifest needed to find the
+// chart layer
+type ociManifest struct {
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Size      int64  `json:"size"`
+	} `json:"layers"`
+}
+
+// manifest fetches the manifest of ref, which may be a tag or a digest,
+// and returns it along with its digest
+func (o *ociClient) manifest(repository, ref string) (*ociManifest, string, error) {
+	resp, err := o.get("/v2/"+repository+"/manifests/"+ref, ociManifestMediaType, "repository:"+repository+":pull")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, ociManifestMaxBytes))
+	if err != nil {
+		return nil, "", err
+	}
+	digest := digestPrefix + archiveDigest(data)
+	if strings.HasPrefix(ref, digestPrefix) && ref != digest {
+		return nil, "", fmt.Errorf("digest of manifest %s is %s", ref, digest)
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, "", fmt.Errorf("invalid manifest %s of %s: %v", ref, repository, err)
+	}
+	return manifest, digest, nil
+}
+
+// checkManifest checks that the manifest ref of repository can be pulled
+// with the credentials of the client, without downloading it
+func (o *ociClient) checkManifest(repository, ref string) error {
+	resp, err := o.do("HEAD", "/v2/"+repository+"/manifests/"+ref, ociManifestMediaType, "repository:"+repository+":pull")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// blob fetches the blob digest of repository, and checks its digest
+func (o *ociClient) blob(repository, digest string) ([]byte, error) {
+	resp, err := o.get("/v2/"+repository+"/blobs/"+digest, "", "repository:"+repository+":pull")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if got := digestPrefix + archiveDigest(data); got != digest {
+		return nil, fmt.Errorf("digest of blob %s is %s", digest, got)
+	}
+	return data, nil
+}
+
+// chartLayer returns the digest of the chart layer of manifest
+func (m *ociManifest) chartLayer() (string, error) {
+	for _, layer := range m.Layers {
+		if layer.MediaType == ociChartMediaType || layer.MediaType == ociLegacyChartMediaType {
+			return layer.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("manifest has no chart layer")
+}
+
+// ociChart resolves the OCI source of hr. Tags are resolved to the
+// digest of their manifest, the chart layer being only pulled when the
+// chart is loaded.
+func (c *Controller) ociChart(hr *v1.HelmRelease) (*chartSource, error) {
+	oci := hr.Spec.Source.OCI
+	ref, err := parseOCIReference(oci.URL)
+	if err != nil {
+		return nil, err
+	}
+	pinned := oci.Digest
+	if pinned == "" {
+		pinned = ref.digest
+	}
+	if ref.digest != "" && pinned != ref.digest {
+		return nil, fmt.Errorf("digest %s doesn't match the one of %s", oci.Digest, oci.URL)
+	}
+	client, err := c.ociClient(hr.Namespace, oci, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &chartSource{
+		url:    oci.URL,
+		source: &v1.SourceStatus{URL: oci.URL, Revision: pinned},
+	}
+	var manifest *ociManifest
+	if ref.digest == "" {
+		// Tags are resolved on every reconcile, even for pinned charts,
+		// so that moved tags are reported
+		manifest, s.source.Revision, err = client.manifest(ref.repository, ref.tag)
+		if err != nil {
+			return nil, err
+		}
+		if pinned != "" && s.source.Revision != pinned {
+			return nil, fmt.Errorf("tag %s of %s resolves to %s, not to the pinned digest %s", ref.tag, ref.repository, s.source.Revision, pinned)
+		}
+	}
+
+	s.load = func() (*chart.Chart, error) {
+		key := chartCacheKey(oci.URL, "", "", s.source.Revision, "")
+		if data, ok := c.charts.Get(key); ok {
+			// Cached charts are shared by all releases, so the access of
+			// this one to the registry is checked, unless it was when
+			// resolving the tag
+			if manifest == nil {
+				if err := client.checkManifest(ref.repository, s.source.Revision); err != nil {
+					return nil, err
+				}
+			}
+			chartCacheRequests.WithLabelValues("hit").Inc()
+			glog.V(2).Infof("Using cached chart %s@%s", oci.URL, s.source.Revision)
+			return s.loadArchive(data)
+		}
+		chartCacheRequests.WithLabelValues("miss").Inc()
+
+		if manifest == nil {
+			if manifest, _, err = client.manifest(ref.repository, s.source.Revision); err != nil {
+				return nil, err
+			}
+		}
+		layer, err := manifest.chartLayer()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", oci.URL, err)
+		}
+		glog.Infof("Pulling %s@%s ...", oci.URL, s.source.Revision)
+		registry := "oci://" + ref.host + "/" + ref.repository
+		start := time.Now()
+		data, err := client.blob(ref.repository, layer)
+		chartDownloadDuration.WithLabelValues(registry).Observe(time.Since(start).Seconds())
+		if err != nil {
+			return nil, err
+		}
+		chartDownloadBytes.WithLabelValues(registry).Add(float64(len(data)))
+		c.charts.Add(key, data)
+		return s.loadArchive(data)
+	}
+	return s, nil
+}
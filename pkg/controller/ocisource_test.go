@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// newTestRegistry serves archive as the chart charts/mychart:1.0.0 of a
+// registry requiring a bearer token, obtained with user:secret. It
+// returns the registry and the digest of the manifest.
+func newTestRegistry(t *testing.T, archive []byte) (*httptest.Server, string) {
+	layer := digestPrefix + archiveDigest(archive)
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"layers": []map[string]interface{}{
+			{"mediaType": ociChartMediaType, "digest": layer, "size": len(archive)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := digestPrefix + archiveDigest(manifest)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"token": "t0ken"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/charts/mychart/manifests/1.0.0", "/v2/charts/mychart/manifests/" + digest:
+			w.Header().Set("Content-Type", ociManifestMediaType)
+			w.Write(manifest)
+		case "/v2/charts/mychart/blobs/" + layer:
+			w.Write(archive)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server, digest
+}
+
+func TestUpdateReleaseFromOCI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-crd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newTestRepo(t, dir).Close()
+	archive, err := ioutil.ReadFile(filepath.Join(dir, "repo", "mychart-1.0.0.tgz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, digest := newTestRegistry(t, archive)
+	defer registry.Close()
+
+	host := strings.TrimPrefix(registry.URL, "http://")
+	chartURL := "oci://" + host + "/charts/mychart:1.0.0"
+	pullSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "registry"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths": {"` + host + `": {"auth": "dXNlcjpzZWNyZXQ="}}}`),
+		},
+	}
+
+	tests := []struct {
+		name       string
+		source     v1.OCISource
+		pullSecret bool
+		wantErr    bool
+	}{
+		{
+			name:       "tag",
+			source:     v1.OCISource{URL: chartURL},
+			pullSecret: true,
+		},
+		{
+			name:       "pinned tag",
+			source:     v1.OCISource{URL: chartURL, Digest: digest},
+			pullSecret: true,
+		},
+		{
+			name:       "digest",
+			source:     v1.OCISource{URL: "oci://" + host + "/charts/mychart@" + digest},
+			pullSecret: true,
+		},
+		{
+			name:       "moved tag",
+			source:     v1.OCISource{URL: chartURL, Digest: "sha256:0123"},
+			pullSecret: true,
+			wantErr:    true,
+		},
+		{
+			name:    "no credentials",
+			source:  v1.OCISource{URL: chartURL},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease("")
+			source := tt.source
+			source.PlainHTTP = true
+			if tt.pullSecret {
+				source.PullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+			}
+			hr.Spec.Source = &v1.ChartSource{OCI: &source}
+			c, clientset := newTestController(t, NewFakeBackend(), hr, pullSecret)
+
+			err := c.updateRelease(testNamespace + "/" + testName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateRelease() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := clientset.HelmV1().HelmReleases(testNamespace).Get(testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Source == nil || got.Status.Source.Revision != digest || got.Status.ResolvedVersion != "1.0.0" {
+				t.Errorf("source = %+v, version %q, want revision %s, version 1.0.0", got.Status.Source, got.Status.ResolvedVersion, digest)
+			}
+		})
+	}
+
+	// A chart cached for a release with access to the registry isn't
+	// served to one without
+	pinnedURL := "oci://" + host + "/charts/mychart@" + digest
+	allowed := newTestRelease("")
+	allowed.Spec.Source = &v1.ChartSource{OCI: &v1.OCISource{URL: pinnedURL, PlainHTTP: true,
+		PullSecrets: []corev1.LocalObjectReference{{Name: "registry"}}}}
+	denied := newTestRelease("")
+	denied.Name = "other"
+	denied.Spec.Source = &v1.ChartSource{OCI: &v1.OCISource{URL: pinnedURL, PlainHTTP: true}}
+	c, _ := newTestController(t, NewFakeBackend(), allowed, denied, pullSecret)
+	if err := c.updateRelease(testNamespace + "/" + testName); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateRelease(testNamespace + "/other"); err == nil {
+		t.Errorf("cached chart was served without credentials")
+	}
+}
@@ -0,0 +1,269 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// defaultTimeout is the timeout in seconds of install/upgrade/delete
+// operations, as with the helm CLI
+const defaultTimeout = 300
+
+// releaseTimeout returns the spec.timeout of hr, or its default
+func releaseTimeout(hr *v1.HelmRelease) int64 {
+	if hr.Spec.Timeout > 0 {
+		return hr.Spec.Timeout
+	}
+	return defaultTimeout
+}
+
+// waitEnabled returns whether installs/upgrades of hr wait for the
+// release resources to be ready
+func waitEnabled(hr *v1.HelmRelease) bool {
+	return hr.Spec.Wait || hr.Spec.Atomic
+}
+
+// operation is an install or upgrade of a release, along with what the
+// HelmRelease status records once it is done
+type operation struct {
+	install      bool
+	generation   int64
+	url          string
+	version      string
+	source       *v1.SourceStatus
+	poll         bool
+	chartVersion string
+	valuesHash   string
+	// lastGoodRevision is the revision a failed upgrade is rolled back
+	// to, as recorded in the status when the operation started, 0 if
+	// unknown
+	lastGoodRevision int32
+
+	// done is closed once the results below are set, when the
+	// operation runs in the background
+	done chan struct{}
+	rel  *release.Release
+	err  error
+
+	// purgeErr is the outcome of the purge of a failed install, with
+	// spec.atomic
+	purgeErr error
+	// rolledBack is set if the release was rolled back after the upgrade
+	// failed, with spec.atomic or spec.rollback, or its tests failed,
+	// with spec.test.rollbackOnFailure. rollbackTarget, rollback and
+	// rollbackErr are the outcome, rollbackTarget being 0 if no revision
+	// was found to roll back to.
+	rolledBack     bool
+	rollbackTarget int32
+	rollback       *release.Release
+	rollbackErr    error
+
+	// tests and testErr are the outcome of the release tests started at
+	// testStart, with spec.test
+	tests     *release.TestSuite
+	testErr   error
+	testStart metav1.Time
+}
+
+// runsInBackground returns whether the operations of hr may wait for the
+// release resources or tests, and are then run in the background
+func runsInBackground(hr *v1.HelmRelease) bool {
+	return waitEnabled(hr) || testEnabled(hr) || (rollbackEnabled(hr) && hr.Spec.Rollback.Wait)
+}
+
+// runOperation installs or upgrades the release of hr to ch, then runs
+// the release tests with spec.test. Failed installs are purged and failed
+// upgrades rolled back with spec.atomic or spec.rollback, as are upgrades
+// failing their tests with spec.test.rollbackOnFailure.
+func (c *Controller) runOperation(op *operation, hr *v1.HelmRelease, ch *chart.Chart, values []byte) {
+	rlsName := releaseName(hr.Namespace, hr.Name)
+	if op.install {
+		glog.Infof("Installing release %s into namespace %s", rlsName, hr.Namespace)
+		op.rel, op.err = c.backend.Install(ch, InstallOptions{
+			Name:         rlsName,
+			Namespace:    hr.Namespace,
+			Values:       values,
+			Wait:         waitEnabled(hr),
+			Timeout:      releaseTimeout(hr),
+			DisableHooks: hr.Spec.DisableHooks,
+		})
+	} else {
+		glog.Infof("Update release %s with options UpgradeForce(%v)/UpgradeRecreate(%v)/UpgradeWait(%v)",
+			rlsName, hr.Spec.Force, hr.Spec.Recreate, waitEnabled(hr))
+		op.rel, op.err = c.backend.Upgrade(hr.Namespace, rlsName, ch, UpgradeOptions{
+			Values:       values,
+			Force:        hr.Spec.Force,
+			Recreate:     hr.Spec.Recreate,
+			Wait:         waitEnabled(hr),
+			Timeout:      releaseTimeout(hr),
+			DisableHooks: hr.Spec.DisableHooks,
+		})
+	}
+	switch {
+	case op.err != nil && op.install && hr.Spec.Atomic:
+		glog.Infof("Install of release %s failed, purging it: %v", rlsName, op.err)
+		op.purgeErr = c.backend.Delete(hr.Namespace, rlsName, DeleteOptions{
+			Purge:        true,
+			Timeout:      releaseTimeout(hr),
+			DisableHooks: hr.Spec.DisableHooks,
+		})
+		if op.purgeErr == ErrReleaseNotFound {
+			op.purgeErr = nil
+		}
+	case op.err != nil && !op.install && (rollbackEnabled(hr) || hr.Spec.Atomic):
+		c.runRollback(op, hr, op.err)
+	case op.err == nil && testEnabled(hr):
+		c.runTests(op, hr)
+		if err := testsError(op); err != nil && hr.Spec.Test.RollbackOnFailure && !op.install {
+			c.runRollback(op, hr, err)
+		}
+	}
+}
+
+// runInBackground runs op in a goroutine, so that waiting for the release
+// resources, tests or rollbacks doesn't hold a worker, and requeues key once it is
+// done. In the meantime hr is in the Deploying phase.
+func (c *Controller) runInBackground(key string, op *operation, hr *v1.HelmRelease, ch *chart.Chart, values []byte) error {
+	op.done = make(chan struct{})
+	c.operationsLock.Lock()
+	c.operations[key] = op
+	c.operationsLock.Unlock()
+	go func() {
+		c.runOperation(op, hr, ch, values)
+		close(op.done)
+		c.queue.Add(key)
+	}()
+
+	action := "Upgrading"
+	if op.install {
+		action = "Installing"
+	}
+	msg := fmt.Sprintf("%s release %s", action, releaseName(hr.Namespace, hr.Name))
+	if waitEnabled(hr) {
+		msg += fmt.Sprintf(", waiting up to %ds for its resources", releaseTimeout(hr))
+	}
+	if testEnabled(hr) {
+		msg += ", then testing it"
+	}
+	hr.Status.Phase = v1.HelmRealeasePhaseDeploying
+	hr.Status.FailMsg = ""
+	setCondition(&hr.Status, v1.HelmReleaseConditionReady, corev1.ConditionFalse, reasonDeploying, msg)
+	c.recorder.Event(hr, corev1.EventTypeNormal, reasonDeploying, msg)
+	_, err := c.updateStatus(hr)
+	return err
+}
+
+// pendingOperation returns the operation of key, running or done but not
+// yet recorded, nil if there is none
+func (c *Controller) pendingOperation(key string) *operation {
+	c.operationsLock.Lock()
+	defer c.operationsLock.Unlock()
+	return c.operations[key]
+}
+
+// finishedOperation returns the operation of key once it is done, and
+// whether one is still running
+func (c *Controller) finishedOperation(key string) (op *operation, running bool) {
+	c.operationsLock.Lock()
+	defer c.operationsLock.Unlock()
+	op = c.operations[key]
+	if op == nil {
+		return nil, false
+	}
+	select {
+	case <-op.done:
+		delete(c.operations, key)
+		return op, false
+	default:
+		return nil, true
+	}
+}
+
+// finishOperation records the outcome of op, including the purge or
+// rollback following its failure or the failure of its tests, in the
+// status of hr
+func (c *Controller) finishOperation(key string, hr *v1.HelmRelease, op *operation) error {
+	rlsName := releaseName(hr.Namespace, hr.Name)
+	hr.Status.ObservedGeneration = op.generation
+	hr.Status.LastAttemptedVersion = op.chartVersion
+	if op.generation != hr.Generation {
+		// The spec changed while waiting
+		defer c.queue.Add(key)
+	}
+
+	rel, err := op.rel, op.err
+	if op.install {
+		if err != nil {
+			setCondition(&hr.Status, v1.HelmReleaseConditionInstalled, corev1.ConditionFalse, reasonInstallFailed, err.Error())
+			c.warningEventf(key, hr, reasonInstallFailed, "Failed to install release %s: %v", rlsName, err)
+			if hr.Spec.Atomic {
+				if derr := op.purgeErr; derr != nil {
+					c.warningEventf(key, hr, reasonDeleteFailed, "Failed to purge release %s: %v", rlsName, derr)
+					return &wrapError{hr, fmt.Errorf("%v (purge failed: %v)", err, derr)}
+				}
+				c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonDeleted, "Purged release %s after a failed install", rlsName)
+			}
+			return &wrapError{hr, err}
+		}
+		setCondition(&hr.Status, v1.HelmReleaseConditionInstalled, corev1.ConditionTrue, reasonInstalled,
+			fmt.Sprintf("Installed revision %d", rel.GetVersion()))
+		c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonInstalled, "Installed release %s revision %d", rlsName, rel.GetVersion())
+	} else {
+		if err != nil {
+			setCondition(&hr.Status, v1.HelmReleaseConditionInstalled, corev1.ConditionFalse, reasonUpgradeFailed, err.Error())
+			c.warningEventf(key, hr, reasonUpgradeFailed, "Failed to upgrade release %s: %v", rlsName, err)
+			if op.rolledBack {
+				return c.recordRollback(key, hr, op, err)
+			}
+			return &wrapError{hr, err}
+		}
+		setCondition(&hr.Status, v1.HelmReleaseConditionInstalled, corev1.ConditionTrue, reasonUpgraded,
+			fmt.Sprintf("Upgraded to revision %d", rel.GetVersion()))
+		c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonUpgraded, "Upgraded release %s to revision %d", rlsName, rel.GetVersion())
+	}
+
+	if !op.testStart.IsZero() {
+		if err := c.recordTests(key, hr, op); err != nil && op.rolledBack {
+			return c.recordRollback(key, hr, op, err)
+		}
+	}
+
+	status, err := c.backend.Status(hr.Namespace, rel.Name)
+	if err == nil {
+		glog.Infof("Installed/updated release %s, version %d (status %s)", rel.Name, rel.Version, status.Code)
+	} else {
+		glog.Warningf("Unable to fetch release status for %s: %v", rel.Name, err)
+	}
+
+	now := metav1.Now()
+	hr.Status.ChartURL = op.url
+	hr.Status.Revision = rel.GetVersion()
+	hr.Status.LastGoodRevision = rel.GetVersion()
+	hr.Status.Failures = 0
+	hr.Status.Phase = v1.HelmRealeasePhaseReady
+	hr.Status.FailMsg = ""
+	hr.Status.LastAppliedValuesHash = op.valuesHash
+	hr.Status.LastSuccessTime = &now
+	if op.source == nil && op.poll && hr.Status.ResolvedVersion != "" && hr.Status.ResolvedVersion != op.version {
+		c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonVersionUpdated,
+			"Adopted chart version %s (was %s) matching %q", op.version, hr.Status.ResolvedVersion, hr.Spec.Version)
+	}
+	hr.Status.ResolvedVersion = op.version
+	hr.Status.Source = op.source
+	setCondition(&hr.Status, v1.HelmReleaseConditionReady, corev1.ConditionTrue, "ReconcileSucceeded", "")
+	if _, err := c.updateStatus(hr); err != nil {
+		return &wrapError{hr, err}
+	}
+	c.forgetWarnings(key)
+	if c.driftCheckEnabled(hr) {
+		c.queue.AddAfter(key, c.config.DriftInterval)
+	}
+	return nil
+}
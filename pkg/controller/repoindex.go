@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/repo"
+)
+
+// repoIndexCache holds the parsed index of each chart repository, shared
+// by all workers. Indexes older than the refresh interval are refreshed
+// with conditional requests, so that unchanged indexes are not
+// downloaded and parsed again.
+type repoIndexCache struct {
+	refreshInterval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*repoIndex
+}
+
+// repoIndex is the cached index of a repository. Its lock is held while
+// it is refreshed, so that workers needing the same index wait for a
+// single download.
+type repoIndex struct {
+	mu           sync.Mutex
+	url          string
+	index        *repo.IndexFile
+	etag         string
+	lastModified string
+	lastRefresh  time.Time
+	lastAttempt  time.Time
+	lastError    error
+}
+
+// indexStatusError is the error of an index download answered with an
+// unexpected HTTP status
+type indexStatusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e *indexStatusError) Error() string {
+	return fmt.Sprintf("Failed to fetch %s : %s", e.url, e.status)
+}
+
+// isAccessDenied returns whether err is a refusal of the repository to
+// serve its index with the given credentials
+func isAccessDenied(err error) bool {
+	if e, ok := err.(*indexStatusError); ok {
+		return e.code == http.StatusUnauthorized || e.code == http.StatusForbidden
+	}
+	return false
+}
+
+func newRepoIndexCache(refreshInterval time.Duration) *repoIndexCache {
+	return &repoIndexCache{
+		refreshInterval: refreshInterval,
+		entries:         map[string]*repoIndex{},
+	}
+}
+
+// entry returns the cache entry of repoURL for the credentials hashing
+// to credentials. Repositories may show different charts to different
+// users, and indexes fetched with valid credentials must not be served
+// to invalid ones, so entries are per credentials.
+func (c *repoIndexCache) entry(repoURL, credentials string) *repoIndex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := repoURL + "\x00" + credentials
+	e, ok := c.entries[key]
+	if !ok {
+		e = &repoIndex{url: repoURL}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// Get returns the index of repoURL, refreshing it if it was last
+// refreshed, or last tried to be, more than maxAge ago. If the refresh
+// fails, the previous index is returned, if any, unless the repository
+// denied access to it.
+func (c *repoIndexCache) Get(repoURL string, creds *repoCredentials, getters getter.Providers, maxAge time.Duration) (*repo.IndexFile, error) {
+	e := c.entry(repoURL, creds.cacheKey())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.index != nil && time.Since(e.lastAttempt) < maxAge {
+		return e.index, nil
+	}
+	e.lastAttempt = time.Now()
+	err := e.refresh(repoURL, creds, getters)
+	if err != nil {
+		e.lastError = err
+		repoIndexRefreshes.WithLabelValues(repoURL, "error").Inc()
+		if isAccessDenied(err) {
+			e.index = nil
+		}
+		if e.index != nil {
+			glog.Warningf("Unable to refresh the index of %s, using the one of %s: %v", repoURL, e.lastRefresh.Format(time.RFC3339), err)
+			return e.index, nil
+		}
+		return nil, fmt.Errorf("Looks like %q is not a valid chart repository or cannot be reached: %s", repoURL, err)
+	}
+	e.lastError = nil
+	repoIndexLastRefresh.WithLabelValues(repoURL).Set(float64(e.lastRefresh.Unix()))
+	return e.index, nil
+}
+
+func (e *repoIndex) refresh(repoURL string, creds *repoCredentials, getters getter.Providers) error {
+	indexURL, err := url.Parse(repoURL)
+	if err != nil {
+		return err
+	}
+	indexURL.Path = strings.TrimSuffix(indexURL.Path, "/") + "/index.yaml"
+
+	var data []byte
+	var etag, lastModified string
+	switch indexURL.Scheme {
+	case "http", "https":
+		data, etag, lastModified, err = e.fetch(indexURL.String(), creds)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			glog.V(2).Infof("Index of %s is unchanged", repoURL)
+			repoIndexRefreshes.WithLabelValues(repoURL, "unchanged").Inc()
+			e.lastRefresh = time.Now()
+			return nil
+		}
+	default:
+		// Getters of other schemes (plugins) can't make conditional
+		// requests
+		g, err := chartGetter(indexURL.String(), creds, getters)
+		if err != nil {
+			return err
+		}
+		buf, err := g.Get(indexURL.String())
+		if err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	index, err := parseIndex(data)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Refreshed the index of %s", repoURL)
+	repoIndexRefreshes.WithLabelValues(repoURL, "updated").Inc()
+	e.index = index
+	e.etag = etag
+	e.lastModified = lastModified
+	e.lastRefresh = time.Now()
+	return nil
+}
+
+// fetch downloads indexURL, along with its ETag and Last-Modified
+// headers, unless it is unchanged since the last download, in which case
+// it returns a nil slice
+func (e *repoIndex) fetch(indexURL string, creds *repoCredentials) (data []byte, etag, lastModified string, err error) {
+	client, err := creds.httpClient(indexURL)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req, err := creds.newRequest(indexURL, indexURL)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if e.index != nil {
+		if e.etag != "" {
+			req.Header.Set("If-None-Match", e.etag)
+		}
+		if e.lastModified != "" {
+			req.Header.Set("If-Modified-Since", e.lastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if e.index != nil {
+			return nil, "", "", nil
+		}
+		fallthrough
+	default:
+		return nil, "", "", &indexStatusError{url: indexURL, status: resp.Status, code: resp.StatusCode}
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return nil, "", "", err
+	}
+	return buf.Bytes(), resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// repoIndexStatus describes a cached repository index
+type repoIndexStatus struct {
+	URL         string     `json:"url"`
+	Charts      int        `json:"charts"`
+	LastRefresh *time.Time `json:"lastRefresh,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// Status returns the status of the cached index of repoURL, as seen with
+// creds
+func (c *repoIndexCache) Status(repoURL string, creds *repoCredentials) repoIndexStatus {
+	return c.entry(repoURL, creds.cacheKey()).status()
+}
+
+func (e *repoIndex) status() repoIndexStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := repoIndexStatus{URL: e.url}
+	if e.index != nil {
+		status.Charts = len(e.index.Entries)
+		lastRefresh := e.lastRefresh
+		status.LastRefresh = &lastRefresh
+	}
+	if e.lastError != nil {
+		status.LastError = e.lastError.Error()
+	}
+	return status
+}
+
+// ServeHTTP lists the cached repository indexes, with the time of their
+// last refresh and the error of the last failed one, as JSON
+func (c *repoIndexCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	entries := make([]*repoIndex, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	c.mu.Unlock()
+
+	statuses := []repoIndexStatus{}
+	for _, e := range entries {
+		statuses = append(statuses, e.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].URL < statuses[j].URL })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		glog.Warningf("Unable to write the repository statuses: %v", err)
+	}
+}
+
+// parseIndex parses a repository index. The repo package only loads
+// indexes from files, which takes care of sorting entries and of
+// legacy formats.
+func parseIndex(data []byte) (*repo.IndexFile, error) {
+	f, err := ioutil.TempFile("", "helm-crd-index-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return repo.LoadIndexFile(f.Name())
+}
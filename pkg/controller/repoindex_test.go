@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/helm/pkg/getter"
+)
+
+const testIndex = `apiVersion: v1
+entries:
+  mychart:
+  - name: mychart
+    version: 1.0.0
+    urls:
+    - mychart-1.0.0.tgz
+`
+
+func TestRepoIndexCache(t *testing.T) {
+	var downloads, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testIndex))
+	}))
+	defer server.Close()
+
+	cache := newRepoIndexCache(time.Hour)
+	creds := &repoCredentials{}
+	for i := 0; i < 2; i++ {
+		index, err := cache.Get(server.URL, creds, getter.Providers{}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !index.Has("mychart", "1.0.0") {
+			t.Fatalf("index lacks mychart 1.0.0: %v", index.Entries)
+		}
+	}
+	if downloads != 1 || notModified != 0 {
+		t.Errorf("got %d downloads and %d conditional requests, want 1 and 0", downloads, notModified)
+	}
+
+	index, err := cache.Get(server.URL, creds, getter.Providers{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !index.Has("mychart", "1.0.0") {
+		t.Errorf("index lacks mychart 1.0.0 after refresh: %v", index.Entries)
+	}
+	if downloads != 1 || notModified != 1 {
+		t.Errorf("got %d downloads and %d conditional requests, want 1 and 1", downloads, notModified)
+	}
+
+	server.Close()
+	if _, err := cache.Get(server.URL, creds, getter.Providers{}, 0); err != nil {
+		t.Errorf("stale index was not used while the repository is down: %v", err)
+	}
+	if e := cache.entry(server.URL, creds.cacheKey()); e.lastError == nil {
+		t.Errorf("refresh error was not recorded")
+	}
+}
+
+func TestRepoIndexCacheCredentials(t *testing.T) {
+	var downloads int
+	denied := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); denied || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		downloads++
+		w.Write([]byte(testIndex))
+	}))
+	defer server.Close()
+
+	cache := newRepoIndexCache(time.Hour)
+	valid := &repoCredentials{username: "user", password: "pass"}
+	if _, err := cache.Get(server.URL, valid, getter.Providers{}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index fetched with valid credentials isn't served to others
+	invalid := &repoCredentials{username: "user", password: "wrong"}
+	if _, err := cache.Get(server.URL, invalid, getter.Providers{}, time.Hour); err == nil {
+		t.Errorf("index was served with a wrong password")
+	}
+
+	// Forced refreshes are rate limited
+	if _, err := cache.Get(server.URL, valid, getter.Providers{}, indexRefetchInterval); err != nil {
+		t.Fatal(err)
+	}
+	if downloads != 1 {
+		t.Errorf("got %d downloads, want 1", downloads)
+	}
+
+	// A revoked access isn't hidden by the cached index
+	denied = true
+	if _, err := cache.Get(server.URL, valid, getter.Providers{}, 0); err == nil {
+		t.Errorf("stale index was served after the repository denied access")
+	}
+	if _, err := cache.Get(server.URL, valid, getter.Providers{}, time.Hour); err == nil {
+		t.Errorf("stale index was served after the repository denied access")
+	}
+}
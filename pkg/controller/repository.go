@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/helm/pkg/getter"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const (
+	kindHelmRepository        = "HelmRepository"
+	kindClusterHelmRepository = "ClusterHelmRepository"
+
+	// repositoryIndex indexes HelmReleases by the repository they refer
+	// to in spec.repositoryRef
+	repositoryIndex = "repository"
+)
+
+// repositoryKey identifies a HelmRepository or ClusterHelmRepository, ns
+// being empty for the latter. It is used both as index and queue key.
+func repositoryKey(kind, ns, name string) string {
+	if kind == kindClusterHelmRepository {
+		return kind + "/" + name
+	}
+	return kind + "/" + ns + "/" + name
+}
+
+func repositoryRefKind(ref *v1.RepositoryReference) string {
+	if ref.Kind == "" {
+		return kindHelmRepository
+	}
+	return ref.Kind
+}
+
+// repositoryIndexFunc returns the key of the repository referenced by a
+// HelmRelease in spec.repositoryRef
+func repositoryIndexFunc(obj interface{}) ([]string, error) {
+	hr, ok := obj.(*v1.HelmRelease)
+	if !ok || hr.Spec.RepositoryRef == nil {
+		return nil, nil
+	}
+	ref := hr.Spec.RepositoryRef
+	return []string{repositoryKey(repositoryRefKind(ref), hr.Namespace, ref.Name)}, nil
+}
+
+// chartRepository is the repository a chart is fetched from
+type chartRepository struct {
+	url             string
+	creds           *repoCredentials
+	refreshInterval time.Duration
+}
+
+// chartRepository returns the repository of the chart of hr, either
+// referenced by spec.repositoryRef or given by spec.repoURL
+func (c *Controller) chartRepository(hr *v1.HelmRelease) (*chartRepository, error) {
+	ref := hr.Spec.RepositoryRef
+	if ref == nil {
+		creds, err := c.repoCredentials(hr)
+		if err != nil {
+			return nil, err
+		}
+		repoURL := hr.Spec.RepoURL
+		if repoURL == "" {
+			repoURL = c.config.DefaultRepoURL
+		}
+		return &chartRepository{url: repoURL, creds: creds, refreshInterval: c.indexes.refreshInterval}, nil
+	}
+
+	kind := repositoryRefKind(ref)
+	var (
+		obj    interface{}
+		exists bool
+		err    error
+		ns     string
+	)
+	switch kind {
+	case kindHelmRepository:
+		ns = hr.Namespace
+		obj, exists, err = c.repoInformer.GetIndexer().GetByKey(ns + "/" + ref.Name)
+	case kindClusterHelmRepository:
+		obj, exists, err = c.clusterRepoInformer.GetIndexer().GetByKey(ref.Name)
+	default:
+		return nil, fmt.Errorf("repositoryRef kind must be %s or %s, not %q", kindHelmRepository, kindClusterHelmRepository, ref.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s %s not found", kind, strings.TrimPrefix(repositoryKey(kind, ns, ref.Name), kind+"/"))
+	}
+	if kind == kindHelmRepository {
+		return c.repositoryFromSpec(ns, obj.(*v1.HelmRepository).Spec)
+	}
+	return c.repositoryFromSpec("", obj.(*v1.ClusterHelmRepository).Spec)
+}
+
+// repositoryFromSpec returns the repository described by spec, of a
+// HelmRepository in ns or of a ClusterHelmRepository if ns is empty
+func (c *Controller) repositoryFromSpec(ns string, spec v1.HelmRepositorySpec) (*chartRepository, error) {
+	if spec.URL == "" {
+		return nil, fmt.Errorf("repository has no url")
+	}
+	r := &chartRepository{
+		url:             spec.URL,
+		creds:           &repoCredentials{},
+		refreshInterval: c.indexes.refreshInterval,
+	}
+	if spec.RefreshInterval != nil && spec.RefreshInterval.Duration > 0 {
+		r.refreshInterval = spec.RefreshInterval.Duration
+	}
+	if ref := spec.CredentialsSecretRef; ref != nil {
+		secretNs := ref.Namespace
+		switch {
+		case ns == "" && secretNs == "":
+			return nil, fmt.Errorf("credentialsSecretRef of a %s must set a namespace", kindClusterHelmRepository)
+		case secretNs == "":
+			secretNs = ns
+		case ns != "" && secretNs != ns:
+			// Only cluster-wide repositories may read Secrets of other
+			// namespaces
+			return nil, fmt.Errorf("credentialsSecretRef of a %s must be in its namespace %s, not %s", kindHelmRepository, ns, secretNs)
+		}
+		creds, err := c.secretCredentials(secretNs, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		r.creds = creds
+	}
+	if spec.CABundle != "" {
+		if err := r.creds.writeFile("ca-bundle.crt", []byte(spec.CABundle), &r.creds.caFile); err != nil {
+			r.creds.cleanup()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// repositoryEventHandler queues repositories of the given kind for a
+// status refresh, and requeues the HelmReleases referring to them when
+// their spec changes.
+func (c *Controller) repositoryEventHandler(kind string) cache.ResourceEventHandler {
+	enqueue := func(obj interface{}, releases bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		meta, err := apimeta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		key := repositoryKey(kind, meta.GetNamespace(), meta.GetName())
+		c.repoQueue.Add(key)
+		if !releases {
+			return
+		}
+		hrs, err := c.informer.GetIndexer().ByIndex(repositoryIndex, key)
+		if err != nil {
+			glog.Errorf("Error looking up HelmReleases referring to %s: %v", key, err)
+			return
+		}
+		for _, hr := range hrs {
+			if key, err := cache.MetaNamespaceKeyFunc(hr); err == nil {
+				c.queue.Add(key)
+			}
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { enqueue(obj, true) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, err := apimeta.Accessor(oldObj)
+			if err != nil {
+				return
+			}
+			newMeta, err := apimeta.Accessor(newObj)
+			if err != nil {
+				return
+			}
+			// Status updates don't bump the generation
+			if oldMeta.GetGeneration() != newMeta.GetGeneration() {
+				enqueue(newObj, true)
+			}
+		},
+		DeleteFunc: func(obj interface{}) { enqueue(obj, true) },
+	}
+}
+
+func (c *Controller) runRepositoryWorker() {
+	for c.processNextRepository() {
+		// continue looping
+	}
+}
+
+func (c *Controller) processNextRepository() bool {
+	key, quit := c.repoQueue.Get()
+	if quit {
+		return false
+	}
+	defer c.repoQueue.Done(key)
+
+	if err := c.syncRepository(key.(string)); err != nil {
+		runtime.HandleError(fmt.Errorf("Error syncing %s: %v", key, err))
+		c.repoQueue.AddRateLimited(key)
+		return true
+	}
+	c.repoQueue.Forget(key)
+	return true
+}
+
+// syncRepository refreshes the index of the repository at key, records
+// the outcome in its status, and schedules the next refresh
+func (c *Controller) syncRepository(key string) error {
+	parts := strings.SplitN(key, "/", 2)
+	kind, objKey := parts[0], parts[1]
+	informer := c.repoInformer
+	if kind == kindClusterHelmRepository {
+		informer = c.clusterRepoInformer
+	}
+	obj, exists, err := informer.GetIndexer().GetByKey(objKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	var (
+		ns         string
+		spec       v1.HelmRepositorySpec
+		status     v1.HelmRepositoryStatus
+		generation int64
+	)
+	switch repo := obj.(type) {
+	case *v1.HelmRepository:
+		ns, spec, status, generation = repo.Namespace, repo.Spec, repo.Status, repo.Generation
+	case *v1.ClusterHelmRepository:
+		spec, status, generation = repo.Spec, repo.Status, repo.Generation
+	}
+
+	newStatus := v1.HelmRepositoryStatus{ObservedGeneration: generation}
+	r, err := c.repositoryFromSpec(ns, spec)
+	if err != nil {
+		newStatus.LastError = err.Error()
+		c.repoQueue.AddAfter(key, c.indexes.refreshInterval)
+	} else {
+		defer r.creds.cleanup()
+		c.repoQueue.AddAfter(key, r.refreshInterval)
+		// Failures are recorded in the status of the index
+		c.indexes.Get(r.url, r.creds, r.creds.getters(r.url, getter.All(c.config.Helm)), r.refreshInterval)
+		indexStatus := c.indexes.Status(r.url, r.creds)
+		newStatus.Ready = indexStatus.LastError == ""
+		newStatus.LastError = indexStatus.LastError
+		newStatus.Charts = int32(indexStatus.Charts)
+		if indexStatus.LastRefresh != nil {
+			// The API server only keeps seconds
+			t := metav1.NewTime(indexStatus.LastRefresh.Truncate(time.Second))
+			newStatus.LastRefreshTime = &t
+		}
+	}
+	if repositoryStatusEqual(status, newStatus) {
+		return nil
+	}
+
+	switch repo := obj.(type) {
+	case *v1.HelmRepository:
+		repo = repo.DeepCopy()
+		repo.Status = newStatus
+		_, err = c.clientset.HelmV1().HelmRepositories(repo.Namespace).UpdateStatus(repo)
+	case *v1.ClusterHelmRepository:
+		repo = repo.DeepCopy()
+		repo.Status = newStatus
+		_, err = c.clientset.HelmV1().ClusterHelmRepositories().UpdateStatus(repo)
+	}
+	return err
+}
+
+func repositoryStatusEqual(a, b v1.HelmRepositoryStatus) bool {
+	if (a.LastRefreshTime == nil) != (b.LastRefreshTime == nil) ||
+		a.LastRefreshTime != nil && !a.LastRefreshTime.Equal(b.LastRefreshTime) {
+		return false
+	}
+	a.LastRefreshTime, b.LastRefreshTime = nil, nil
+	return a == b
+}
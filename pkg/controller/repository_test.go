@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+func TestRepositoryCredentialsNamespace(t *testing.T) {
+	tests := []struct {
+		name    string
+		ns      string
+		ref     corev1.SecretReference
+		wantErr bool
+	}{
+		{name: "HelmRepository defaults to its namespace", ns: testNamespace, ref: corev1.SecretReference{Name: "creds"}},
+		{name: "HelmRepository in its namespace", ns: testNamespace, ref: corev1.SecretReference{Namespace: testNamespace, Name: "creds"}},
+		{name: "HelmRepository in another namespace", ns: testNamespace, ref: corev1.SecretReference{Namespace: "kube-system", Name: "creds"}, wantErr: true},
+		{name: "ClusterHelmRepository in any namespace", ref: corev1.SecretReference{Namespace: "kube-system", Name: "creds"}},
+		{name: "ClusterHelmRepository without namespace", ref: corev1.SecretReference{Name: "creds"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestController(t, NewFakeBackend())
+			for _, ns := range []string{testNamespace, "kube-system"} {
+				c.secretInformer.GetIndexer().Add(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "creds"},
+					Data:       map[string][]byte{"username": []byte("user"), "password": []byte(ns)},
+				})
+			}
+			ref := tt.ref
+			r, err := c.repositoryFromSpec(tt.ns, v1.HelmRepositorySpec{URL: "https://charts.example.com", CredentialsSecretRef: &ref})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("repositoryFromSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := ref.Namespace
+			if want == "" {
+				want = tt.ns
+			}
+			if r.creds.password != want {
+				t.Errorf("got the credentials of namespace %s, want %s", r.creds.password, want)
+			}
+		})
+	}
+}
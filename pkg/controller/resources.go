@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"encoding/json"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// dynamicResources reads and writes resources of any type, as found in
+// release manifests
+type dynamicResources struct {
+	mapper *discovery.DeferredDiscoveryRESTMapper
+	pool   dynamic.ClientPool
+}
+
+func newDynamicResources(kubeconfig *rest.Config, disc discovery.CachedDiscoveryInterface) *dynamicResources {
+	mapper := discovery.NewDeferredDiscoveryRESTMapper(disc, meta.InterfacesForUnstructured)
+	return &dynamicResources{
+		mapper: mapper,
+		pool:   dynamic.NewClientPool(rest.CopyConfig(kubeconfig), mapper, dynamic.LegacyAPIPathResolverFunc),
+	}
+}
+
+// client returns a client for the resource type of obj
+func (r *dynamicResources) client(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The type may have been registered since discovery ran, e.g.
+		// by a CustomResourceDefinition of the release
+		r.mapper.Reset()
+		mapping, err = r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := r.pool.ClientForGroupVersionKind(gvk)
+	if err != nil {
+		return nil, err
+	}
+	namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	if !namespaced {
+		obj.SetNamespace("")
+	}
+	return client.Resource(&metav1.APIResource{
+		Name:       mapping.Resource,
+		Namespaced: namespaced,
+		Kind:       gvk.Kind,
+	}, obj.GetNamespace()), nil
+}
+
+// Get returns the live state of obj
+func (r *dynamicResources) Get(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	client, err := r.client(obj)
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(obj.GetName(), metav1.GetOptions{})
+}
+
+// Apply creates obj, or patches the fields it sets into the live
+// resource. Fields it doesn't set are left alone.
+func (r *dynamicResources) Apply(obj *unstructured.Unstructured) error {
+	client, err := r.client(obj)
+	if err != nil {
+		return err
+	}
+	_, err = client.Get(obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = client.Create(obj)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	patchType := types.StrategicMergePatchType
+	if _, err := scheme.Scheme.New(obj.GroupVersionKind()); err != nil {
+		patchType = types.MergePatchType
+	}
+	_, err = client.Patch(obj.GetName(), patchType, patch)
+	return err
+}
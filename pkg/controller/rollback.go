@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const (
+	defaultRollbackTimeout = 300
+	maxRollbackHistory     = 10
+	rollbackRetryDelay     = 30 * time.Second
+
+	// maxReleaseHistory bounds the revisions looked up for a rollback
+	// target
+	maxReleaseHistory = 256
+)
+
+func rollbackEnabled(hr *v1.HelmRelease) bool {
+	return hr.Spec.Rollback != nil && hr.Spec.Rollback.Enable
+}
+
+// lastGoodRevision returns the revision recorded in the status of hr as
+// the one to roll back to, 0 if there is none
+func lastGoodRevision(hr *v1.HelmRelease) int32 {
+	if hr.Status.LastGoodRevision > 0 {
+		return hr.Status.LastGoodRevision
+	}
+	return hr.Status.Revision
+}
+
+// rollbackSpec returns how the release of hr is rolled back. Without
+// spec.rollback, as with spec.atomic, the rollback waits for the
+// resources with the timeout of the upgrade.
+func rollbackSpec(hr *v1.HelmRelease) *v1.RollbackSpec {
+	if hr.Spec.Rollback != nil {
+		return hr.Spec.Rollback
+	}
+	return &v1.RollbackSpec{Timeout: hr.Spec.Timeout, Wait: true}
+}
+
+// runRollback rolls the release of hr back to the last good revision of
+// op after its upgrade failed, recording the outcome in op
+func (c *Controller) runRollback(op *operation, hr *v1.HelmRelease, upgradeErr error) {
+	rlsName := releaseName(hr.Namespace, hr.Name)
+	op.rolledBack = true
+	op.rollbackTarget, op.rollbackErr = c.rollbackTarget(hr, op.lastGoodRevision)
+	if op.rollbackErr != nil || op.rollbackTarget == 0 {
+		return
+	}
+
+	spec := rollbackSpec(hr)
+	timeout := spec.Timeout
+	if timeout == 0 {
+		timeout = defaultRollbackTimeout
+	}
+	glog.Infof("Upgrade of release %s failed, rolling back to revision %d: %v", rlsName, op.rollbackTarget, upgradeErr)
+	op.rollback, op.rollbackErr = c.backend.Rollback(hr.Namespace, rlsName, RollbackOptions{
+		Revision:     op.rollbackTarget,
+		Timeout:      timeout,
+		Wait:         spec.Wait,
+		Force:        spec.Force,
+		Recreate:     spec.Recreate,
+		DisableHooks: spec.DisableHooks || hr.Spec.DisableHooks,
+	})
+}
+
+// recordRollback records the rollback of op after upgradeErr in the
+// status of hr, and schedules another upgrade attempt if
+// spec.rollback.maxRetries allows it. Without spec.rollback, as with
+// spec.atomic, the upgrade is not retried.
+func (c *Controller) recordRollback(key string, hr *v1.HelmRelease, op *operation, upgradeErr error) error {
+	rlsName := releaseName(hr.Namespace, hr.Name)
+	target, err := op.rollbackTarget, op.rollbackErr
+	switch {
+	case target == 0 && err != nil:
+		return &wrapError{hr, fmt.Errorf("%v (unable to find a revision to roll back to: %v)", upgradeErr, err)}
+	case target == 0:
+		return &wrapError{hr, fmt.Errorf("%v (no good revision to roll back to)", upgradeErr)}
+	case err != nil:
+		c.warningEventf(key, hr, reasonRollbackFailed, "Failed to roll back release %s to revision %d: %v", rlsName, target, err)
+		return &wrapError{hr, fmt.Errorf("%v (rollback to revision %d failed: %v)", upgradeErr, target, err)}
+	}
+
+	rel := op.rollback
+	hr.Status.Phase = v1.HelmRealeasePhaseRolledBack
+	hr.Status.FailMsg = upgradeErr.Error()
+	hr.Status.Revision = rel.GetVersion()
+	hr.Status.LastGoodRevision = rel.GetVersion()
+	hr.Status.Failures++
+	hr.Status.RollbackHistory = append([]v1.RollbackRecord{{
+		Time:     metav1.Now(),
+		Revision: target,
+		Reason:   upgradeErr.Error(),
+	}}, hr.Status.RollbackHistory...)
+	if len(hr.Status.RollbackHistory) > maxRollbackHistory {
+		hr.Status.RollbackHistory = hr.Status.RollbackHistory[:maxRollbackHistory]
+	}
+	setCondition(&hr.Status, v1.HelmReleaseConditionReady, corev1.ConditionFalse, reasonRolledBack,
+		fmt.Sprintf("Upgrade failed, rolled back to revision %d", target))
+	c.recorder.Eventf(hr, corev1.EventTypeWarning, reasonRolledBack, "Rolled back release %s to revision %d", rlsName, target)
+	if _, err := c.updateStatus(hr); err != nil {
+		return err
+	}
+
+	if canRetryUpgrade(hr) {
+		glog.Infof("Retrying upgrade of release %s in %s (%d/%d)", rlsName, rollbackRetryDelay, hr.Status.Failures, hr.Spec.Rollback.MaxRetries)
+		c.queue.AddAfter(key, rollbackRetryDelay)
+	}
+	return nil
+}
+
+// rollbackTarget returns the revision the release of hr is rolled back
+// to: lastGood or, for releases deployed before it was recorded, the
+// last one deployed in the release history. 0 is returned if there is
+// none.
+func (c *Controller) rollbackTarget(hr *v1.HelmRelease, lastGood int32) (int32, error) {
+	if lastGood > 0 {
+		return lastGood, nil
+	}
+	revs, err := c.backend.History(hr.Namespace, releaseName(hr.Namespace, hr.Name), maxReleaseHistory)
+	if err != nil {
+		return 0, err
+	}
+	var target int32
+	for _, rev := range revs {
+		switch rev.GetInfo().GetStatus().GetCode() {
+		case release.Status_DEPLOYED, release.Status_SUPERSEDED:
+			// Superseded revisions were deployed before a later
+			// upgrade, which failed if no revision is deployed
+			if rev.GetVersion() > target {
+				target = rev.GetVersion()
+			}
+		}
+	}
+	return target, nil
+}
+
+// canRetryUpgrade returns whether a rolled back release may be upgraded
+// again
+func canRetryUpgrade(hr *v1.HelmRelease) bool {
+	return rollbackEnabled(hr) &&
+		hr.Status.Phase == v1.HelmRealeasePhaseRolledBack &&
+		hr.Status.Failures <= hr.Spec.Rollback.MaxRetries
+}
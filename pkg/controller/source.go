@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/downloader"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// chartSource is the chart of a HelmRelease, resolved from spec.source or
+// from a chart repository. Resolving is cheap, so that releases which are
+// up to date are skipped before their chart is loaded.
+type chartSource struct {
+	// url is recorded in status.chartURL
+	url string
+	// version is the chart version resolved from a chart repository
+	version string
+	// source is recorded in status.source for charts which don't come
+	// from a chart repository
+	source *v1.SourceStatus
+
+	load   func() (*chart.Chart, error)
+	verify func() error
+	// cleanup, if set, releases the credentials of the source
+	cleanup func()
+}
+
+// revision identifies the resolved chart in logs
+func (s *chartSource) revision() string {
+	if s.source != nil {
+		return s.source.Revision
+	}
+	return s.version
+}
+
+// unchanged returns whether the chart is the one of the last successful
+// install/upgrade recorded in status
+func (s *chartSource) unchanged(status *v1.HelmReleaseStatus) bool {
+	if s.source == nil {
+		return status.Source == nil && s.version == status.ResolvedVersion
+	}
+	return status.Source != nil && *status.Source == *s.source
+}
+
+// loadArchive loads the chart archive data, and records its version
+func (s *chartSource) loadArchive(data []byte) (*chart.Chart, error) {
+	ch, err := chartutil.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %v", err)
+	}
+	s.version = ch.GetMetadata().GetVersion()
+	return ch, nil
+}
+
+// pollsChart returns whether the chart of hr is resolved again every
+// poll interval: version ranges, Git branches and tags, OCI tags and
+// tarballs without a digest
+func pollsChart(hr *v1.HelmRelease) bool {
+	src := hr.Spec.Source
+	switch {
+	case src == nil:
+		return isVersionRange(hr.Spec.Version)
+	case src.Git != nil:
+		ref, _, err := gitRef(src.Git.Ref)
+		return err == nil && ref != ""
+	case src.Tarball != nil:
+		return src.Tarball.Digest == ""
+	case src.OCI != nil:
+		ref, err := parseOCIReference(src.OCI.URL)
+		return err == nil && ref.digest == "" && src.OCI.Digest == ""
+	}
+	return false
+}
+
+// resolveChart resolves the chart of hr. The key and hr are used to
+// report the verification of charts from repositories.
+func (c *Controller) resolveChart(key string, hr *v1.HelmRelease) (*chartSource, error) {
+	verify, err := verifyStrategy(hr.Spec.Verify)
+	if err != nil {
+		return nil, err
+	}
+
+	src := hr.Spec.Source
+	if src == nil {
+		return c.repositoryChart(key, hr, verify)
+	}
+	if verify != downloader.VerifyNever {
+		return nil, fmt.Errorf("verify is only supported for charts from chart repositories")
+	}
+	set := 0
+	for _, isSet := range []bool{src.Git != nil, src.Tarball != nil, src.OCI != nil, src.ConfigMapKeyRef != nil, src.SecretKeyRef != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("source must set exactly one of git, tarball, oci, configMapKeyRef and secretKeyRef")
+	}
+	switch {
+	case src.Git != nil:
+		return c.gitChart(hr)
+	case src.Tarball != nil:
+		return c.tarballChart(hr)
+	case src.OCI != nil:
+		return c.ociChart(hr)
+	}
+	return c.objectChart(hr)
+}
+
+// repositoryChart resolves spec.chartName and spec.version of hr in its
+// chart repository
+func (c *Controller) repositoryChart(key string, hr *v1.HelmRelease, verify downloader.VerificationStrategy) (*chartSource, error) {
+	repository, err := c.chartRepository(hr)
+	if err != nil {
+		return nil, err
+	}
+	getters := repository.creds.getters(repository.url, getter.All(c.config.Helm))
+
+	cv, chartURL, err := c.findChartVersion(repository, getters, hr.Spec.ChartName, hr.Spec.Version)
+	if err != nil {
+		repository.creds.cleanup()
+		return nil, err
+	}
+
+	var (
+		g    getter.Getter
+		data []byte
+	)
+	s := &chartSource{
+		url:     chartURL,
+		version: cv.Version,
+		cleanup: repository.creds.cleanup,
+	}
+	s.load = func() (*chart.Chart, error) {
+		g, err = chartGetter(chartURL, repository.creds, getters)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = c.fetchChart(repository.url, repository.creds, cv, chartURL, g); err != nil {
+			return nil, err
+		}
+		return s.loadArchive(data)
+	}
+	if verify != downloader.VerifyNever {
+		s.verify = func() error {
+			return c.verifyRelease(key, hr, data, chartURL, g, verify)
+		}
+	}
+	return s, nil
+}
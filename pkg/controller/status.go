@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// setCondition sets the condition of the given type, only moving its
+// LastTransitionTime if the status changed.
+func setCondition(status *v1.HelmReleaseStatus, condType v1.HelmReleaseConditionType, condStatus corev1.ConditionStatus, reason, message string) {
+	cond := v1.HelmReleaseCondition{
+		Type:               condType,
+		Status:             condStatus,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+	for i := range status.Conditions {
+		if status.Conditions[i].Type != condType {
+			continue
+		}
+		if status.Conditions[i].Status == condStatus {
+			cond.LastTransitionTime = status.Conditions[i].LastTransitionTime
+		}
+		status.Conditions[i] = cond
+		return
+	}
+	status.Conditions = append(status.Conditions, cond)
+}
+
+// conditionStatus returns the status of the condition condType, Unknown
+// if it is not set
+func conditionStatus(status *v1.HelmReleaseStatus, condType v1.HelmReleaseConditionType) corev1.ConditionStatus {
+	for _, cond := range status.Conditions {
+		if cond.Type == condType {
+			return cond.Status
+		}
+	}
+	return corev1.ConditionUnknown
+}
+
+func valuesHash(values []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(values))
+}
+
+// updateStatus writes the status of hr through the status subresource
+func (c *Controller) updateStatus(hr *v1.HelmRelease) (*v1.HelmRelease, error) {
+	return c.clientset.HelmV1().HelmReleases(hr.Namespace).UpdateStatus(hr)
+}
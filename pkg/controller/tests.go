@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/timeconv"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// testEnabled returns whether the release tests of hr are run after
+// installs and upgrades
+func testEnabled(hr *v1.HelmRelease) bool {
+	return hr.Spec.Test != nil && hr.Spec.Test.Enable
+}
+
+// runTests runs the release tests of the release op deployed, recording
+// the results in the status of hr as they come
+func (c *Controller) runTests(op *operation, hr *v1.HelmRelease) {
+	spec := hr.Spec.Test
+	timeout := spec.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	glog.Infof("Testing release %s revision %d", op.rel.GetName(), op.rel.GetVersion())
+	op.testStart = metav1.Now()
+	progress := &v1.TestStatus{
+		Revision:  op.rel.GetVersion(),
+		StartTime: &op.testStart,
+	}
+	op.tests, op.testErr = c.backend.Test(hr.Namespace, op.rel.GetName(), TestOptions{
+		Timeout: timeout,
+		Cleanup: spec.Cleanup,
+		Progress: func(run *release.TestRun) {
+			progress.Results = setTestResult(progress.Results, testResult(run))
+			c.recordTestProgress(hr.Namespace, hr.Name, progress)
+		},
+	})
+}
+
+// recordTestProgress records the results of the running tests in the
+// status of the HelmRelease name. Failures are only logged, the results
+// being recorded again once the tests are done.
+func (c *Controller) recordTestProgress(namespace, name string, tests *v1.TestStatus) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		hr, err := c.clientset.HelmV1().HelmReleases(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		hr.Status.Tests = tests.DeepCopy()
+		_, err = c.updateStatus(hr)
+		return err
+	})
+	if err != nil {
+		glog.Warningf("Unable to record the test progress of HelmRelease %s/%s: %v", namespace, name, err)
+	}
+}
+
+// setTestResult returns results with res replacing the result of the
+// same test, if any
+func setTestResult(results []v1.TestResult, res v1.TestResult) []v1.TestResult {
+	for i := range results {
+		if results[i].Name == res.Name {
+			results[i] = res
+			return results
+		}
+	}
+	return append(results, res)
+}
+
+// testResult returns how run is recorded in the status
+func testResult(run *release.TestRun) v1.TestResult {
+	return v1.TestResult{
+		Name:    run.GetName(),
+		Phase:   testPhase(run.GetStatus()),
+		Message: run.GetInfo(),
+	}
+}
+
+// testStatus returns the status recording the outcome of the release
+// tests of op
+func testStatus(op *operation) *v1.TestStatus {
+	status := &v1.TestStatus{
+		Revision:  op.rel.GetVersion(),
+		StartTime: &op.testStart,
+	}
+	if suite := op.tests; suite != nil {
+		if suite.StartedAt != nil {
+			t := metav1.NewTime(timeconv.Time(suite.StartedAt))
+			status.StartTime = &t
+		}
+		if suite.CompletedAt != nil {
+			t := metav1.NewTime(timeconv.Time(suite.CompletedAt))
+			status.CompletionTime = &t
+		}
+		for _, run := range suite.Results {
+			status.Results = append(status.Results, testResult(run))
+		}
+	}
+	return status
+}
+
+// testsError returns an error if the release tests of op failed
+func testsError(op *operation) error {
+	if op.testErr != nil {
+		return op.testErr
+	}
+	var failed []string
+	for _, run := range op.tests.GetResults() {
+		if run.GetStatus() != release.TestRun_SUCCESS {
+			failed = append(failed, run.GetName())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d/%d tests failed: %s", len(failed), len(op.tests.GetResults()), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// recordTests records the outcome of the release tests of op in the
+// status of hr and its Tested condition, and returns an error if they
+// failed
+func (c *Controller) recordTests(key string, hr *v1.HelmRelease, op *operation) error {
+	rlsName := releaseName(hr.Namespace, hr.Name)
+	status := testStatus(op)
+	hr.Status.Tests = status
+	if err := testsError(op); err != nil {
+		setCondition(&hr.Status, v1.HelmReleaseConditionTested, corev1.ConditionFalse, reasonTestFailed, err.Error())
+		c.warningEventf(key, hr, reasonTestFailed, "Tests of release %s revision %d failed: %v", rlsName, op.rel.GetVersion(), err)
+		return err
+	}
+	msg := fmt.Sprintf("%d tests passed on revision %d", len(status.Results), op.rel.GetVersion())
+	setCondition(&hr.Status, v1.HelmReleaseConditionTested, corev1.ConditionTrue, reasonTested, msg)
+	c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonTested, "Release %s: %s", rlsName, msg)
+	return nil
+}
+
+// testPhase returns the phase of a test as recorded in the status, such
+// as Success for SUCCESS
+func testPhase(status release.TestRun_Status) string {
+	s := status.String()
+	return s[:1] + strings.ToLower(s[1:])
+}
@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// tillerBackend is a ReleaseBackend talking to Tiller over gRPC
+type tillerBackend struct {
+	client helm.Interface
+}
+
+func newTillerBackend(host string) *tillerBackend {
+	return &tillerBackend{
+		client: instrumentedHelmClient{helm.NewClient(helm.Host(host), helm.ConnectTimeout(5))},
+	}
+}
+
+// releaseNotFound matches the error Tiller's release storage returns for
+// unknown releases
+var releaseNotFound = regexp.MustCompile(`^release: ".*" not found$`)
+
+func isNotFound(err error) bool {
+	// Ideally this would be `grpc.Code(err) == codes.NotFound`,
+	// but it seems helm doesn't return grpc codes. Matching any "not
+	// found" would also catch a missing chart or Kubernetes resource.
+	return releaseNotFound.MatchString(grpc.ErrorDesc(err))
+}
+
+func tillerError(err error) error {
+	if err != nil && isNotFound(err) {
+		return ErrReleaseNotFound
+	}
+	return err
+}
+
+func (t *tillerBackend) Install(chart *chart.Chart, opts InstallOptions) (*release.Release, error) {
+	res, err := t.client.InstallReleaseFromChart(
+		chart,
+		opts.Namespace,
+		helm.ValueOverrides(opts.Values),
+		helm.ReleaseName(opts.Name),
+		helm.InstallWait(opts.Wait),
+		helm.InstallTimeout(opts.Timeout),
+		helm.InstallDisableHooks(opts.DisableHooks),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return res.GetRelease(), nil
+}
+
+func (t *tillerBackend) Upgrade(namespace, name string, chart *chart.Chart, opts UpgradeOptions) (*release.Release, error) {
+	res, err := t.client.UpdateReleaseFromChart(
+		name,
+		chart,
+		helm.UpdateValueOverrides(opts.Values),
+		helm.UpgradeForce(opts.Force),
+		helm.UpgradeRecreate(opts.Recreate),
+		helm.UpgradeWait(opts.Wait),
+		helm.UpgradeTimeout(opts.Timeout),
+		helm.UpgradeDisableHooks(opts.DisableHooks),
+	)
+	if err != nil {
+		return nil, tillerError(err)
+	}
+	return res.GetRelease(), nil
+}
+
+func (t *tillerBackend) Delete(namespace, name string, opts DeleteOptions) error {
+	_, err := t.client.DeleteRelease(
+		name,
+		helm.DeletePurge(opts.Purge),
+		helm.DeleteTimeout(opts.Timeout),
+		helm.DeleteDisableHooks(opts.DisableHooks),
+	)
+	return tillerError(err)
+}
+
+func (t *tillerBackend) History(namespace, name string, max int32) ([]*release.Release, error) {
+	res, err := t.client.ReleaseHistory(name, helm.WithMaxHistory(max))
+	if err != nil {
+		return nil, tillerError(err)
+	}
+	return res.GetReleases(), nil
+}
+
+func (t *tillerBackend) Status(namespace, name string) (*release.Status, error) {
+	res, err := t.client.ReleaseStatus(name)
+	if err != nil {
+		return nil, tillerError(err)
+	}
+	return res.GetInfo().GetStatus(), nil
+}
+
+func (t *tillerBackend) Content(namespace, name string) (*release.Release, error) {
+	res, err := t.client.ReleaseContent(name)
+	if err != nil {
+		return nil, tillerError(err)
+	}
+	return res.GetRelease(), nil
+}
+
+func (t *tillerBackend) Rollback(namespace, name string, opts RollbackOptions) (*release.Release, error) {
+	res, err := t.client.RollbackRelease(
+		name,
+		helm.RollbackVersion(opts.Revision),
+		helm.RollbackTimeout(opts.Timeout),
+		helm.RollbackWait(opts.Wait),
+		helm.RollbackForce(opts.Force),
+		helm.RollbackRecreate(opts.Recreate),
+		helm.RollbackDisableHooks(opts.DisableHooks),
+	)
+	if err != nil {
+		return nil, tillerError(err)
+	}
+	return res.GetRelease(), nil
+}
+
+// Test runs the release tests and returns the resulting test suite, as
+// recorded by Tiller on the release
+func (t *tillerBackend) Test(namespace, name string, opts TestOptions) (*release.TestSuite, error) {
+	msgs, errc := t.client.RunReleaseTest(
+		name,
+		helm.ReleaseTestTimeout(opts.Timeout),
+		helm.ReleaseTestCleanup(opts.Cleanup),
+	)
+	// msgs is nil if Tiller couldn't be reached, errc is closed or holds
+	// the error once msgs is closed
+	if msgs != nil {
+		for msg := range msgs {
+			glog.Infof("Release %s: %s", name, msg.GetMsg())
+			if run := testRun(msg); run != nil && opts.Progress != nil {
+				opts.Progress(run)
+			}
+		}
+	}
+	if err := <-errc; err != nil {
+		return nil, tillerError(err)
+	}
+
+	res, err := t.client.ReleaseContent(name)
+	if err != nil {
+		return nil, tillerError(err)
+	}
+	return res.GetRelease().GetInfo().GetStatus().GetLastTestSuiteRun(), nil
+}
+
+// testRun returns the test a message streamed by Tiller during a test run
+// reports on, such as "PASSED: mychart-test", nil for the messages not
+// about a single test
+func testRun(msg *services.TestReleaseResponse) *release.TestRun {
+	if msg.GetStatus() == release.TestRun_UNKNOWN {
+		return nil
+	}
+	i := strings.Index(msg.GetMsg(), ": ")
+	if i < 0 {
+		return nil
+	}
+	fields := strings.Fields(msg.GetMsg()[i+2:])
+	if len(fields) == 0 {
+		return nil
+	}
+	return &release.TestRun{
+		Name:   strings.TrimSuffix(fields[0], ","),
+		Status: msg.GetStatus(),
+		Info:   msg.GetMsg(),
+	}
+}
+
+func (t *tillerBackend) Ping() error {
+	return t.client.PingTiller()
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+func TestTillerError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{nil, nil},
+		{grpc.Errorf(codes.Unknown, `release: "default-myrelease" not found`), ErrReleaseNotFound},
+		{errors.New(`release: "default-myrelease" not found`), ErrReleaseNotFound},
+		{grpc.Errorf(codes.Unknown, `configmaps "values" not found`), nil},
+		{grpc.Errorf(codes.Unknown, `chart "mychart" matching 1.0.0 not found in stable index`), nil},
+	}
+	for _, tt := range tests {
+		got := tillerError(tt.err)
+		if tt.want == nil {
+			tt.want = tt.err
+		}
+		if got != tt.want {
+			t.Errorf("tillerError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestTestRun(t *testing.T) {
+	tests := []struct {
+		msg    string
+		status release.TestRun_Status
+		want   string
+	}{
+		{"RUNNING: mychart-test", release.TestRun_RUNNING, "mychart-test"},
+		{"PASSED: mychart-test", release.TestRun_SUCCESS, "mychart-test"},
+		{"FAILED: mychart-test, run `kubectl logs mychart-test --namespace default` for more info", release.TestRun_FAILURE, "mychart-test"},
+		{"No Tests Found", release.TestRun_UNKNOWN, ""},
+		{"UNKNOWN: mychart-test", release.TestRun_UNKNOWN, ""},
+		{"FAILED:", release.TestRun_FAILURE, ""},
+	}
+	for _, tt := range tests {
+		run := testRun(&services.TestReleaseResponse{Msg: tt.msg, Status: tt.status})
+		if got := run.GetName(); got != tt.want {
+			t.Errorf("testRun(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+		if run != nil && (run.Status != tt.status || run.Info != tt.msg) {
+			t.Errorf("testRun(%q) = %+v, want status %s", tt.msg, run, tt.status)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+const (
+	// valuesFromIndex indexes HelmReleases by the ConfigMaps and Secrets
+	// they reference in spec.valuesFrom and spec.source
+	valuesFromIndex = "valuesFrom"
+
+	kindConfigMap = "ConfigMap"
+	kindSecret    = "Secret"
+)
+
+func valuesFromIndexKey(kind, ns, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, ns, name)
+}
+
+// valuesFromIndexFunc returns the index keys of all objects referenced by
+// a HelmRelease in spec.valuesFrom and spec.source
+func valuesFromIndexFunc(obj interface{}) ([]string, error) {
+	hr, ok := obj.(*v1.HelmRelease)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for _, ref := range hr.Spec.ValuesFrom {
+		if ref.ConfigMapKeyRef != nil {
+			keys = append(keys, valuesFromIndexKey(kindConfigMap, hr.Namespace, ref.ConfigMapKeyRef.Name))
+		}
+		if ref.SecretKeyRef != nil {
+			keys = append(keys, valuesFromIndexKey(kindSecret, hr.Namespace, ref.SecretKeyRef.Name))
+		}
+	}
+	if src := hr.Spec.Source; src != nil {
+		if src.ConfigMapKeyRef != nil {
+			keys = append(keys, valuesFromIndexKey(kindConfigMap, hr.Namespace, src.ConfigMapKeyRef.Name))
+		}
+		if src.SecretKeyRef != nil {
+			keys = append(keys, valuesFromIndexKey(kindSecret, hr.Namespace, src.SecretKeyRef.Name))
+		}
+	}
+	return keys, nil
+}
+
+func newCoreInformer(client rest.Interface, resource string, objType runtime.Object) cache.SharedIndexInformer {
+	lw := cache.NewListWatchFromClient(client, resource, metav1.NamespaceAll, fields.Everything())
+	return cache.NewSharedIndexInformer(lw, objType, 0, cache.Indexers{})
+}
+
+// valuesFromReference fetches the content of the key selected by ref.
+// A nil slice is returned if the object or key is missing and the
+// reference is optional.
+func (c *Controller) valuesFromReference(ns string, ref v1.ValuesReference) ([]byte, error) {
+	switch {
+	case ref.ConfigMapKeyRef != nil && ref.SecretKeyRef != nil:
+		return nil, fmt.Errorf("valuesFrom entry must set only one of configMapKeyRef and secretKeyRef")
+	case ref.ConfigMapKeyRef != nil:
+		sel := ref.ConfigMapKeyRef
+		optional := sel.Optional != nil && *sel.Optional
+		obj, exists, err := c.configMapInformer.GetIndexer().GetByKey(ns + "/" + sel.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			if optional {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("configmap %s/%s not found", ns, sel.Name)
+		}
+		data, ok := obj.(*corev1.ConfigMap).Data[sel.Key]
+		if !ok {
+			if optional {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("key %q not found in configmap %s/%s", sel.Key, ns, sel.Name)
+		}
+		return []byte(data), nil
+	case ref.SecretKeyRef != nil:
+		sel := ref.SecretKeyRef
+		optional := sel.Optional != nil && *sel.Optional
+		obj, exists, err := c.secretInformer.GetIndexer().GetByKey(ns + "/" + sel.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			if optional {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("secret %s/%s not found", ns, sel.Name)
+		}
+		data, ok := obj.(*corev1.Secret).Data[sel.Key]
+		if !ok {
+			if optional {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("key %q not found in secret %s/%s", sel.Key, ns, sel.Name)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("valuesFrom entry must set one of configMapKeyRef and secretKeyRef")
+}
+
+// composeValues merges the values referenced in spec.valuesFrom in order,
+// followed by the inline spec.values, and returns the resulting YAML.
+func (c *Controller) composeValues(hr *v1.HelmRelease) ([]byte, error) {
+	if len(hr.Spec.ValuesFrom) == 0 {
+		return []byte(hr.Spec.RawValues), nil
+	}
+
+	values := map[string]interface{}{}
+	for _, ref := range hr.Spec.ValuesFrom {
+		data, err := c.valuesFromReference(hr.Namespace, ref)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		ref, err := parseValues(data, ref.TargetPath)
+		if err != nil {
+			return nil, err
+		}
+		values = mergeValues(values, ref)
+	}
+
+	inline, err := parseValues([]byte(hr.Spec.RawValues), "")
+	if err != nil {
+		return nil, err
+	}
+	values = mergeValues(values, inline)
+
+	return yaml.Marshal(values)
+}
+
+// parseValues parses data as YAML. If path is not empty, the parsed
+// content is nested under the given dot-separated path.
+func parseValues(data []byte, path string) (map[string]interface{}, error) {
+	if path == "" {
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse values: %v", err)
+		}
+		return values, nil
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to parse values for %q: %v", path, err)
+	}
+	parts := strings.Split(path, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		value = map[string]interface{}{parts[i]: value}
+	}
+	return value.(map[string]interface{}), nil
+}
+
+// mergeValues merges src into dest recursively, values from src taking
+// precedence.
+func mergeValues(dest, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		nextMap, ok := v.(map[string]interface{})
+		if !ok {
+			dest[k] = v
+			continue
+		}
+		destMap, ok := dest[k].(map[string]interface{})
+		if !ok {
+			dest[k] = nextMap
+			continue
+		}
+		dest[k] = mergeValues(destMap, nextMap)
+	}
+	return dest
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+func TestMergeValues(t *testing.T) {
+	tests := []struct {
+		name string
+		dest string
+		src  string
+		want string
+	}{
+		{
+			name: "src takes precedence",
+			dest: "replicas: 1\nimage: nginx\n",
+			src:  "replicas: 2\n",
+			want: "replicas: 2\nimage: nginx\n",
+		},
+		{
+			name: "maps are merged recursively",
+			dest: "db:\n  host: localhost\n  port: 5432\n",
+			src:  "db:\n  host: db.example.com\n  user: app\n",
+			want: "db:\n  host: db.example.com\n  port: 5432\n  user: app\n",
+		},
+		{
+			name: "lists are replaced",
+			dest: "hosts: [a, b]\n",
+			src:  "hosts: [c]\n",
+			want: "hosts: [c]\n",
+		},
+		{
+			name: "map replaces scalar",
+			dest: "db: none\n",
+			src:  "db:\n  host: localhost\n",
+			want: "db:\n  host: localhost\n",
+		},
+		{
+			name: "scalar replaces map",
+			dest: "db:\n  host: localhost\n",
+			src:  "db: none\n",
+			want: "db: none\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parse := func(s string) map[string]interface{} {
+				values := map[string]interface{}{}
+				if err := yaml.Unmarshal([]byte(s), &values); err != nil {
+					t.Fatal(err)
+				}
+				return values
+			}
+			want := parse(tt.want)
+			if got := mergeValues(parse(tt.dest), parse(tt.src)); !reflect.DeepEqual(got, want) {
+				t.Errorf("mergeValues() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestComposeValues(t *testing.T) {
+
+	optional := true
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "values"},
+		Data: map[string]string{
+			"base.yaml":     "replicas: 1\nimage:\n  repository: nginx\n  tag: \"1.13\"\n",
+			"override.yaml": "image:\n  tag: \"1.14\"\n",
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "db"},
+		Data:       map[string][]byte{"password": []byte("s3cr3t")},
+	}
+	configMapRef := func(key string, optional *bool) v1.ValuesReference {
+		return v1.ValuesReference{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "values"}, Key: key, Optional: optional}}
+	}
+
+	tests := []struct {
+		name       string
+		valuesFrom []v1.ValuesReference
+		rawValues  string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:      "inline values only",
+			rawValues: "replicas: 2\n",
+			want:      "replicas: 2\n",
+		},
+		{
+			name:       "later references and inline values take precedence",
+			valuesFrom: []v1.ValuesReference{configMapRef("base.yaml", nil), configMapRef("override.yaml", nil)},
+			rawValues:  "replicas: 3\n",
+			want:       "image:\n  repository: nginx\n  tag: \"1.14\"\nreplicas: 3\n",
+		},
+		{
+			name: "secret at target path",
+			valuesFrom: []v1.ValuesReference{{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password"},
+				TargetPath:   "mariadb.auth.password",
+			}},
+			want: "mariadb:\n  auth:\n    password: s3cr3t\n",
+		},
+		{
+			name:       "missing optional key",
+			valuesFrom: []v1.ValuesReference{configMapRef("base.yaml", nil), configMapRef("missing.yaml", &optional)},
+			want:       cm.Data["base.yaml"],
+		},
+		{
+			name:       "missing key",
+			valuesFrom: []v1.ValuesReference{configMapRef("missing.yaml", nil)},
+			wantErr:    true,
+		},
+		{
+			name: "missing secret",
+			valuesFrom: []v1.ValuesReference{{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Key: "password"},
+			}},
+			wantErr: true,
+		},
+		{
+			name:       "both references set",
+			valuesFrom: []v1.ValuesReference{{ConfigMapKeyRef: configMapRef("base.yaml", nil).ConfigMapKeyRef, SecretKeyRef: &corev1.SecretKeySelector{}}},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := newTestRelease("")
+			hr.Spec.ValuesFrom = tt.valuesFrom
+			hr.Spec.RawValues = tt.rawValues
+			c, _ := newTestController(t, NewFakeBackend(), hr, cm, secret)
+
+			got, err := c.composeValues(hr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("composeValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var gotValues, wantValues map[string]interface{}
+			if err := yaml.Unmarshal(got, &gotValues); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.want), &wantValues); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotValues, wantValues) {
+				t.Errorf("composeValues() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValuesSourceEventHandler(t *testing.T) {
+
+	hr := newTestRelease("")
+	hr.Spec.ValuesFrom = []v1.ValuesReference{
+		{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "values"}, Key: "values.yaml"}},
+		{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "values.yaml"}},
+	}
+
+	tests := []struct {
+		name      string
+		kind      string
+		old       runtime.Object
+		new       runtime.Object
+		wantQueue int
+	}{
+		{
+			name:      "referenced configmap changed",
+			kind:      kindConfigMap,
+			old:       &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "values", ResourceVersion: "1"}},
+			new:       &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "values", ResourceVersion: "2"}},
+			wantQueue: 1,
+		},
+		{
+			name:      "referenced secret changed",
+			kind:      kindSecret,
+			old:       &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "db", ResourceVersion: "1"}},
+			new:       &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "db", ResourceVersion: "2"}},
+			wantQueue: 1,
+		},
+		{
+			name: "referenced configmap resynced",
+			kind: kindConfigMap,
+			old:  &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "values", ResourceVersion: "1"}},
+			new:  &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "values", ResourceVersion: "1"}},
+		},
+		{
+			name: "configmap of another namespace changed",
+			kind: kindConfigMap,
+			old:  &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "values", ResourceVersion: "1"}},
+			new:  &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "values", ResourceVersion: "2"}},
+		},
+		{
+			name: "secret named as the configmap changed",
+			kind: kindSecret,
+			old:  &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "values", ResourceVersion: "1"}},
+			new:  &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "values", ResourceVersion: "2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestController(t, NewFakeBackend(), hr)
+			c.valuesSourceEventHandler(tt.kind).OnUpdate(tt.old, tt.new)
+			if got := c.queue.Len(); got != tt.wantQueue {
+				t.Errorf("%d HelmReleases queued, want %d", got, tt.wantQueue)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/downloader"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/provenance"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// verifyStrategy maps spec.verify to the strategy of the chart downloader
+func verifyStrategy(mode v1.VerifyMode) (downloader.VerificationStrategy, error) {
+	switch mode {
+	case "", v1.VerifyNever:
+		return downloader.VerifyNever, nil
+	case v1.VerifyIfPossible:
+		return downloader.VerifyIfPossible, nil
+	case v1.VerifyAlways:
+		return downloader.VerifyAlways, nil
+	}
+	return downloader.VerifyNever, fmt.Errorf("unknown verify mode %q, must be one of %s, %s or %s",
+		mode, v1.VerifyNever, v1.VerifyIfPossible, v1.VerifyAlways)
+}
+
+// keyring returns the keyring referenced by spec.keyring of hr, or nil
+// if charts are to be verified against the --keyring default
+func (c *Controller) keyring(hr *v1.HelmRelease) ([]byte, error) {
+	ref := hr.Spec.Keyring
+	if ref == nil {
+		return nil, nil
+	}
+
+	switch {
+	case ref.ConfigMapKeyRef != nil && ref.SecretKeyRef != nil:
+		return nil, fmt.Errorf("keyring must set only one of configMapKeyRef and secretKeyRef")
+	case ref.ConfigMapKeyRef != nil:
+		sel := ref.ConfigMapKeyRef
+		obj, exists, err := c.configMapInformer.GetIndexer().GetByKey(hr.Namespace + "/" + sel.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("keyring configmap %s/%s not found", hr.Namespace, sel.Name)
+		}
+		cm := obj.(*corev1.ConfigMap)
+		if data, ok := cm.BinaryData[sel.Key]; ok {
+			return data, nil
+		}
+		if data, ok := cm.Data[sel.Key]; ok {
+			return []byte(data), nil
+		}
+		return nil, fmt.Errorf("key %q not found in keyring configmap %s/%s", sel.Key, hr.Namespace, sel.Name)
+	case ref.SecretKeyRef != nil:
+		sel := ref.SecretKeyRef
+		obj, exists, err := c.secretInformer.GetIndexer().GetByKey(hr.Namespace + "/" + sel.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("keyring secret %s/%s not found", hr.Namespace, sel.Name)
+		}
+		data, ok := obj.(*corev1.Secret).Data[sel.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found in keyring secret %s/%s", sel.Key, hr.Namespace, sel.Name)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("keyring must set one of configMapKeyRef and secretKeyRef")
+}
+
+// verifyChart verifies the chart archive data against the provenance
+// file published next to it at chartURL, using keyring or else the
+// defaultKeyring file. A nil verification is returned if the provenance
+// file is missing and verify is VerifyIfPossible.
+func verifyChart(data []byte, chartURL string, g getter.Getter, verify downloader.VerificationStrategy, keyring []byte, defaultKeyring string) (*provenance.Verification, error) {
+	prov, err := g.Get(chartURL + ".prov")
+	if err != nil {
+		if verify == downloader.VerifyAlways {
+			return nil, fmt.Errorf("failed to fetch provenance %q: %v", chartURL+".prov", err)
+		}
+		glog.Warningf("Verification not found for %s: %v", chartURL, err)
+		return nil, nil
+	}
+
+	dir, err := ioutil.TempDir("", "helm-crd-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	keyringFile := defaultKeyring
+	if keyring != nil {
+		keyringFile = filepath.Join(dir, "pubring.gpg")
+		if err := ioutil.WriteFile(keyringFile, keyring, 0600); err != nil {
+			return nil, err
+		}
+	}
+	// Provenance files name the archive they sign, so it is verified
+	// under its original name
+	u, err := url.Parse(chartURL)
+	if err != nil {
+		return nil, err
+	}
+	fname := filepath.Join(dir, path.Base(u.Path))
+	if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(fname+".prov", prov.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return downloader.VerifyChart(fname, keyringFile)
+}
+
+// verifyRelease verifies the chart archive data of hr downloaded from
+// chartURL, and reports the outcome in its status and events
+func (c *Controller) verifyRelease(key string, hr *v1.HelmRelease, data []byte, chartURL string, g getter.Getter, verify downloader.VerificationStrategy) error {
+	keyring, err := c.keyring(hr)
+	var ver *provenance.Verification
+	if err == nil {
+		ver, err = verifyChart(data, chartURL, g, verify, keyring, c.config.Keyring)
+	}
+	if err != nil {
+		hr.Status.Verification = nil
+		setCondition(&hr.Status, v1.HelmReleaseConditionVerified, corev1.ConditionFalse, reasonVerifyFailed, err.Error())
+		c.warningEventf(key, hr, reasonVerifyFailed, "Failed to verify %s: %v", chartURL, err)
+		return err
+	}
+	hr.Status.Verification = verificationStatus(ver)
+	if verification := hr.Status.Verification; verification != nil {
+		setCondition(&hr.Status, v1.HelmReleaseConditionVerified, corev1.ConditionTrue, reasonVerified,
+			fmt.Sprintf("Signed by %s", strings.Join(verification.SignedBy, ", ")))
+		c.recorder.Eventf(hr, corev1.EventTypeNormal, reasonVerified, "Verified chart %s signed by %s (%s)",
+			chartURL, strings.Join(verification.SignedBy, ", "), verification.FileHash)
+	} else {
+		setCondition(&hr.Status, v1.HelmReleaseConditionVerified, corev1.ConditionFalse, reasonNotVerified, "Chart has no provenance file")
+	}
+	return nil
+}
+
+// verificationStatus returns the status of a verified chart, or nil if
+// the chart was not verified
+func verificationStatus(ver *provenance.Verification) *v1.VerificationStatus {
+	if ver == nil || ver.SignedBy == nil {
+		return nil
+	}
+	status := &v1.VerificationStatus{
+		FileHash: ver.FileHash,
+		Time:     metav1.Now(),
+	}
+	for name := range ver.SignedBy.Identities {
+		status.SignedBy = append(status.SignedBy, name)
+	}
+	sort.Strings(status.SignedBy)
+	return status
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/repo"
+
+	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
+)
+
+// isVersionRange returns whether version is a semver constraint such as
+// "~2.0" or ">=1.2, <2.0" rather than an exact version
+func isVersionRange(version string) bool {
+	if version == "" {
+		return false
+	}
+	if _, err := semver.NewVersion(version); err == nil {
+		return false
+	}
+	_, err := semver.NewConstraint(version)
+	return err == nil
+}
+
+// pollInterval returns how often the repository is checked for new
+// versions matching the version range of hr
+func (c *Controller) pollInterval(hr *v1.HelmRelease) time.Duration {
+	if hr.Spec.PollInterval != nil && hr.Spec.PollInterval.Duration > 0 {
+		return hr.Spec.PollInterval.Duration
+	}
+	return c.config.PollInterval
+}
+
+// indexRefetchInterval is the minimum age of a cached index refreshed
+// again because it lacks a chart, so that releases of missing charts
+// don't download the index on every reconcile
+const indexRefetchInterval = 10 * time.Second
+
+// findChartVersion looks up the highest version of chartName matching
+// version, which may be an exact version or a semver constraint, in the
+// index of r, and returns it along with its absolute download URL. The
+// cached index is refreshed again if it lacks the chart, in case it was
+// just published.
+func (c *Controller) findChartVersion(r *chartRepository, getters getter.Providers, chartName, version string) (*repo.ChartVersion, string, error) {
+	repoURL, creds := r.url, r.creds
+	// Entries are sorted by descending version when loaded, so the
+	// first match is the highest one
+	index, err := c.indexes.Get(repoURL, creds, getters, r.refreshInterval)
+	if err != nil {
+		return nil, "", err
+	}
+	cv, err := index.Get(chartName, version)
+	if err != nil {
+		if index, err = c.indexes.Get(repoURL, creds, getters, indexRefetchInterval); err != nil {
+			return nil, "", err
+		}
+		cv, err = index.Get(chartName, version)
+	}
+	if err != nil {
+		if version != "" {
+			return nil, "", fmt.Errorf("chart %q version %q not found in %s repository", chartName, version, repoURL)
+		}
+		return nil, "", fmt.Errorf("chart %q not found in %s repository", chartName, repoURL)
+	}
+	if len(cv.URLs) == 0 {
+		return nil, "", fmt.Errorf("chart %q version %q has no downloadable URLs", chartName, cv.Version)
+	}
+
+	chartURL, err := repo.ResolveReferenceURL(repoURL, cv.URLs[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to make chart URL absolute: %v", err)
+	}
+	return cv, chartURL, nil
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/helm/pkg/getter"
+)
+
+func TestIsVersionRange(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"", false},
+		{"1.2.3", false},
+		{"v1.2.3", false},
+		{"1.2", false},
+		{"1.2.3-beta.1", false},
+		{"~1.2", true},
+		{"^1.2.0", true},
+		{">=1.2, <2.0", true},
+		{"1.x", true},
+		{"*", true},
+		{"not a version", false},
+	}
+	for _, tt := range tests {
+		if got := isVersionRange(tt.version); got != tt.want {
+			t.Errorf("isVersionRange(%q) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+const testRangeIndex = `apiVersion: v1
+entries:
+  mychart:
+  - name: mychart
+    version: 1.0.0
+    urls:
+    - mychart-1.0.0.tgz
+  - name: mychart
+    version: 1.2.0
+    urls:
+    - mychart-1.2.0.tgz
+  - name: mychart
+    version: 1.10.1
+    urls:
+    - https://charts.example.com/mychart-1.10.1.tgz
+  - name: mychart
+    version: 2.0.0
+    urls:
+    - mychart-2.0.0.tgz
+  - name: mychart
+    version: 2.1.0-beta.1
+    urls:
+    - mychart-2.1.0-beta.1.tgz
+  - name: nourls
+    version: 1.0.0
+`
+
+func TestFindChartVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testRangeIndex))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		chart       string
+		version     string
+		wantVersion string
+		wantURL     string
+		wantErr     bool
+	}{
+		{chart: "mychart", version: "1.2.0", wantVersion: "1.2.0", wantURL: server.URL + "/mychart-1.2.0.tgz"},
+		{chart: "mychart", version: "", wantVersion: "2.0.0", wantURL: server.URL + "/mychart-2.0.0.tgz"},
+		{chart: "mychart", version: "~1.2", wantVersion: "1.2.0", wantURL: server.URL + "/mychart-1.2.0.tgz"},
+		{chart: "mychart", version: "^1.0", wantVersion: "1.10.1", wantURL: "https://charts.example.com/mychart-1.10.1.tgz"},
+		{chart: "mychart", version: ">=1.2, <2.0", wantVersion: "1.10.1", wantURL: "https://charts.example.com/mychart-1.10.1.tgz"},
+		{chart: "mychart", version: ">=2.1.0-0", wantVersion: "2.1.0-beta.1", wantURL: server.URL + "/mychart-2.1.0-beta.1.tgz"},
+		{chart: "mychart", version: "~3.0", wantErr: true},
+		{chart: "mychart", version: "1.3.0", wantErr: true},
+		{chart: "other", version: "", wantErr: true},
+		{chart: "nourls", version: "1.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		c := &Controller{indexes: newRepoIndexCache(time.Hour)}
+		r := &chartRepository{url: server.URL, creds: &repoCredentials{}, refreshInterval: time.Hour}
+		cv, chartURL, err := c.findChartVersion(r, getter.Providers{}, tt.chart, tt.version)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("findChartVersion(%q, %q) error = %v, wantErr %v", tt.chart, tt.version, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if cv.Version != tt.wantVersion || chartURL != tt.wantURL {
+			t.Errorf("findChartVersion(%q, %q) = %s at %s, want %s at %s",
+				tt.chart, tt.version, cv.Version, chartURL, tt.wantVersion, tt.wantURL)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package controller
 import (
 	"github.com/fengxsong/helm-crd/pkg/apis/helm.bitnami.com/v1"
 	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
 )
 
 // wrapError only care about errors occur during installing or upgrading helm chart
@@ -15,11 +16,17 @@ func (e *wrapError) Error() string {
 	return e.err.Error()
 }
 
+// handleWrapError records the failure in the status of the HelmRelease,
+// err then holding the updated HelmRelease
 func (c *Controller) handleWrapError(err *wrapError) {
 	obj := err.obj.DeepCopy()
 	obj.Status.Phase = v1.HelmRealeasePhaseFailed
 	obj.Status.FailMsg = err.Error()
-	if _, err := c.clientset.HelmV1().HelmReleases(obj.Namespace).Update(obj); err != nil {
-		glog.Error(err.Error())
+	setCondition(&obj.Status, v1.HelmReleaseConditionReady, corev1.ConditionFalse, "ReconcileFailed", err.Error())
+	updated, uerr := c.updateStatus(obj)
+	if uerr != nil {
+		glog.Error(uerr.Error())
+		return
 	}
+	err.obj = updated
 }